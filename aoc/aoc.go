@@ -2,17 +2,31 @@ package aoc
 
 import (
 	"bufio"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
+	"testing"
 )
 
 func GetFilename() string {
-	if len(os.Args) != 2 {
-		log.Fatal("usage: ", os.Args[0], " input-file")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: ", os.Args[0], " [options] input-file")
 	}
 
-	return os.Args[1]
+	return flag.Arg(0)
+}
+
+func Benchmark(name string, f func()) {
+	result := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			f()
+		}
+	})
+	fmt.Printf("%-24s %s\n", name, result)
 }
 
 func GetInputLines(filename string) []string {
@@ -28,6 +42,19 @@ func GetInputLines(filename string) []string {
 	return lines
 }
 
+// WriteFile creates the file and passes it to write.
+func WriteFile(filename string, write func(io.Writer) error) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 func ParseInts(in []string) []int {
 	out := make([]int, len(in))
 	for i, line := range in {
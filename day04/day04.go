@@ -2,103 +2,254 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"bufio"
+	"flag"
 	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-const Size = 5
-
 var WhiteSpace = regexp.MustCompile(" +")
 
-const Mark = 0x80000000
-
+// A Board is square, with any number of rows. Marks are kept separately
+// from the numbers, so that any int may appear on a board.
 type Board struct {
-	cells [Size][Size]int
-	score int
+	size   int
+	cells  []int
+	marked []bool
+
+	// Used by PlayIndexed to avoid rescanning the board.
+	rowMarks, colMarks []int
+	unmarkedSum        int
 }
 type Draw []int
 type Bingo struct {
 	draw   Draw
 	boards []*Board
+	index  map[int][]Location // built on first use by PlayIndexed
+}
+
+// A Win records the draw which completed a board, and the board's score.
+type Win struct {
+	board     int
+	drawIndex int
+	drawn     int
+	score     int
+}
+
+// A Location is a cell on a board.
+type Location struct {
+	board, cell int
 }
 
+var replay = flag.Bool("replay", false, "list every board in the order it wins")
+var bench = flag.Bool("bench", false, "benchmark scanning against indexed marking")
+var generate = flag.Int("generate", 0, "write this many random boards to the input file")
+var generateSize = flag.Int("size", 5, "size of generated boards")
+var seed = flag.Int64("seed", 1, "random seed for -generate")
+
 func main() {
 	filename := aoc.GetFilename()
+
+	if *generate > 0 {
+		aoc.CheckErr(writeRandomBingo(filename, *generate, *generateSize, rand.New(rand.NewSource(*seed))))
+		return
+	}
+
 	bingo := parseBingo(aoc.GetInputLines(filename))
 
-	fmt.Println(part1(bingo))
-	fmt.Println(part2(bingo))
+	if *bench {
+		if !reflect.DeepEqual(bingo.Play(), bingo.PlayIndexed()) {
+			log.Fatal("Scanning and indexed marking disagree")
+		}
+		aoc.Benchmark("scan", func() { bingo.Play() })
+		aoc.Benchmark("indexed", func() { bingo.PlayIndexed() })
+		return
+	}
+
+	wins := bingo.PlayIndexed()
+
+	fmt.Println(part1(wins))
+	fmt.Println(part2(wins))
+
+	if *replay {
+		for _, win := range wins {
+			fmt.Printf("board %d wins on draw %d (%d) with score %d\n",
+				win.board, win.drawIndex+1, win.drawn, win.score)
+		}
+		fmt.Printf("%d of %d boards never win\n", len(bingo.boards)-len(wins), len(bingo.boards))
+	}
+}
+
+func part1(wins []Win) int {
+	if len(wins) == 0 {
+		return 0
+	}
+	return wins[0].score
+}
+
+func part2(wins []Win) int {
+	if len(wins) == 0 {
+		return 0
+	}
+	return wins[len(wins)-1].score
 }
 
-func part1(bingo Bingo) int {
-	for _, drawn := range bingo.draw {
-		//fmt.Printf("Playing %d\n", drawn)
-		for _, board := range bingo.boards {
-			if board.mark(drawn) && board.isComplete() {
-				//fmt.Printf("Board %d has a %d: %v\n", i, drawn, board)
-				return drawn * board.incompleteCellSum()
+// Play draws every number in turn and returns the boards in the order they
+// win. Boards which win on the same draw are listed in board order.
+func (bingo *Bingo) Play() []Win {
+	for _, board := range bingo.boards {
+		board.reset()
+	}
+
+	won := make([]bool, len(bingo.boards))
+	wins := make([]Win, 0)
+
+	for drawIndex, drawn := range bingo.draw {
+		for i, board := range bingo.boards {
+			if !won[i] && board.mark(drawn) && board.isComplete() {
+				won[i] = true
+				wins = append(wins, Win{
+					board:     i,
+					drawIndex: drawIndex,
+					drawn:     drawn,
+					score:     drawn * board.incompleteCellSum(),
+				})
 			}
 		}
 	}
-	return 0
+	return wins
 }
 
-func part2(bingo Bingo) int {
-	stillToWin := len(bingo.boards)
-	for _, drawn := range bingo.draw {
-		//fmt.Printf("Playing %d\n", drawn)
+// PlayIndexed gives the same result as Play, but looks up where each number
+// is rather than scanning every board, and counts the marks on each row and
+// column.
+func (bingo *Bingo) PlayIndexed() []Win {
+	if bingo.index == nil {
+		bingo.index = make(map[int][]Location)
 		for i, board := range bingo.boards {
-			if board != nil && board.mark(drawn) && board.isComplete() {
-				bingo.boards[i] = nil
-				if stillToWin--; stillToWin == 0 {
-					return drawn * board.incompleteCellSum()
-				}
+			for cell, value := range board.cells {
+				bingo.index[value] = append(bingo.index[value], Location{board: i, cell: cell})
 			}
 		}
 	}
-	return 0
+	for _, board := range bingo.boards {
+		board.reset()
+	}
+
+	won := make([]bool, len(bingo.boards))
+	wins := make([]Win, 0)
+
+	for drawIndex, drawn := range bingo.draw {
+		// A board may hold a number more than once, so only score the
+		// winners once every cell for this draw is marked.
+		winners := make([]int, 0)
+		for _, loc := range bingo.index[drawn] {
+			if bingo.boards[loc.board].markCell(loc.cell) {
+				winners = append(winners, loc.board)
+			}
+		}
+
+		for _, i := range winners {
+			if won[i] {
+				continue
+			}
+			won[i] = true
+			wins = append(wins, Win{
+				board:     i,
+				drawIndex: drawIndex,
+				drawn:     drawn,
+				score:     drawn * bingo.boards[i].unmarkedSum,
+			})
+		}
+	}
+	return wins
 }
 
+// parseBingo takes the draw on the first line, followed by boards separated
+// by any number of blank lines.
 func parseBingo(lines []string) Bingo {
-	numBoards := (len(lines) - 1) / (Size + 1)
-	bingo := Bingo{draw: parseDraw(lines[0]), boards: make([]*Board, numBoards)}
+	if len(lines) == 0 {
+		log.Fatal("Empty input")
+	}
+	bingo := Bingo{draw: parseDraw(lines[0]), boards: make([]*Board, 0)}
 
-	for i := 0; i < numBoards; i++ {
-		firstLine := i*(Size+1) + 2
-		lastLine := firstLine + Size
-		bingo.boards[i] = parseBoard(lines[firstLine:lastLine])
+	block := make([]string, 0)
+	for _, line := range append(lines[1:], "") {
+		if line = strings.TrimSpace(line); line != "" {
+			block = append(block, line)
+		} else if len(block) > 0 {
+			bingo.boards = append(bingo.boards, parseBoard(block))
+			block = make([]string, 0)
+		}
 	}
 
 	return bingo
 }
 
 func parseBoard(lines []string) *Board {
-	board := Board{}
+	size := len(lines)
+	board := Board{size: size, cells: make([]int, 0, size*size), marked: make([]bool, size*size)}
 	for row, line := range lines {
-		line = strings.TrimSpace(line)
-		for col, word := range WhiteSpace.Split(line, -1) {
-			board.cells[row][col] = aoc.ParseInt(word)
+		words := WhiteSpace.Split(line, -1)
+		if len(words) != size {
+			log.Fatalf("Board row %d has %d numbers, expected %d: %q", row+1, len(words), size, line)
 		}
+		board.cells = append(board.cells, aoc.ParseInts(words)...)
 	}
 	return &board
 }
 
+func (board *Board) reset() {
+	for i := range board.marked {
+		board.marked[i] = false
+	}
+	if board.rowMarks == nil {
+		board.rowMarks = make([]int, board.size)
+		board.colMarks = make([]int, board.size)
+	}
+	for i := 0; i < board.size; i++ {
+		board.rowMarks[i] = 0
+		board.colMarks[i] = 0
+	}
+	board.unmarkedSum = 0
+	for _, value := range board.cells {
+		board.unmarkedSum += value
+	}
+}
+
+// markCell marks a single cell, and returns true if that completes a row or
+// column.
+func (board *Board) markCell(cell int) bool {
+	if board.marked[cell] {
+		return false
+	}
+	board.marked[cell] = true
+	board.unmarkedSum -= board.cells[cell]
+
+	row, col := cell/board.size, cell%board.size
+	board.rowMarks[row]++
+	board.colMarks[col]++
+	return board.rowMarks[row] == board.size || board.colMarks[col] == board.size
+}
+
 func (board *Board) incompleteCellSum() int {
 	sum := 0
-	for row := 0; row < Size; row++ {
-		for col := 0; col < Size; col++ {
-			value := board.cells[row][col]
-			if value&Mark == 0 {
-				sum += value
-			}
+	for i, value := range board.cells {
+		if !board.marked[i] {
+			sum += value
 		}
 	}
 	return sum
 }
 
 func (board *Board) isComplete() bool {
-	for i := 0; i < Size; i++ {
+	for i := 0; i < board.size; i++ {
 		if board.isRowComplete(i) || board.isColComplete(i) {
 			return true
 		}
@@ -107,9 +258,8 @@ func (board *Board) isComplete() bool {
 }
 
 func (board *Board) isRowComplete(row int) bool {
-	for col := 0; col < Size; col++ {
-		value := board.cells[row][col]
-		if value&Mark == 0 {
+	for col := 0; col < board.size; col++ {
+		if !board.marked[row*board.size+col] {
 			return false
 		}
 	}
@@ -117,27 +267,61 @@ func (board *Board) isRowComplete(row int) bool {
 }
 
 func (board *Board) isColComplete(col int) bool {
-	for row := 0; row < Size; row++ {
-		value := board.cells[row][col]
-		if value&Mark == 0 {
+	for row := 0; row < board.size; row++ {
+		if !board.marked[row*board.size+col] {
 			return false
 		}
 	}
 	return true
 }
 
+// mark marks every cell holding number, and returns true if there were any.
 func (board *Board) mark(number int) bool {
-	for row := 0; row < Size; row++ {
-		for col := 0; col < Size; col++ {
-			if board.cells[row][col] == number {
-				board.cells[row][col] |= Mark
-				return true
-			}
+	found := false
+	for i, value := range board.cells {
+		if value == number {
+			board.marked[i] = true
+			found = true
 		}
 	}
-	return false
+	return found
 }
 
 func parseDraw(line string) Draw {
-	return aoc.ParseInts(strings.Split(line, ","))
+	return aoc.ParseInts(strings.Split(strings.TrimSpace(line), ","))
+}
+
+// writeRandomBingo writes a draw of every number from 0 up to four times the
+// number of cells on a board, followed by boards of distinct numbers from
+// the same range.
+func writeRandomBingo(filename string, boards, size int, rng *rand.Rand) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(file)
+
+	numbers := rng.Perm(size * size * 4)
+	words := make([]string, len(numbers))
+	for i, number := range numbers {
+		words[i] = strconv.Itoa(number)
+	}
+	fmt.Fprintln(out, strings.Join(words, ","))
+
+	for b := 0; b < boards; b++ {
+		fmt.Fprintln(out)
+		cells := rng.Perm(len(numbers))[:size*size]
+		for row := 0; row < size; row++ {
+			for col := 0; col < size; col++ {
+				fmt.Fprintf(out, "%3d", cells[row*size+col])
+			}
+			fmt.Fprintln(out)
+		}
+	}
+
+	if err = out.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
@@ -2,8 +2,12 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
+	"log"
 	"regexp"
+	"sort"
+	"strings"
 )
 
 var LineRegex = regexp.MustCompile("^(\\d+),(\\d+) -> (\\d+),(\\d+)$")
@@ -16,70 +20,436 @@ type Line struct {
 	p [2]Point
 }
 
-type Ocean map[Point]int
+// Which lines to trace. Diagonal lines are those at exactly 45 degrees.
+type Mode int
+
+const (
+	Orthogonal Mode = iota
+	Diagonal
+	AnySlope
+)
+
+// An Ocean counts how many lines pass through each point.
+type Ocean interface {
+	Mark(p Point)
+	Count(p Point) int
+	AtLeast(k int) int
+}
+
+var backend = flag.String("backend", "map", "ocean backend: map, grid or sweep")
+var check = flag.Bool("check", false, "check the sweep backend against the map backend")
+var minOverlap = flag.Int("overlap", 2, "count points with at least this many lines")
+var anySlope = flag.Bool("any", false, "also trace lines at any slope and report a third answer")
+var heatmap = flag.Bool("heatmap", false, "draw the ocean for the last answer")
 
 func main() {
 	filename := aoc.GetFilename()
 	lines := parse(filename)
+
+	if *check {
+		checkSweep(lines)
+		return
+	}
+
 	fmt.Println(part1(lines))
 	fmt.Println(part2(lines))
+
+	last := Diagonal
+	if *anySlope {
+		fmt.Println(part3(lines))
+		last = AnySlope
+	}
+
+	if *heatmap {
+		// The sweep backend never builds an ocean, so draw from a map.
+		name := *backend
+		if name == "sweep" {
+			name = "map"
+		}
+		ocean := MakeOcean(name, lines)
+		traceAll(ocean, lines, last)
+		min, max := Bounds(lines)
+		fmt.Print(Heatmap(ocean, min, max))
+	}
 }
 
 func part1(lines []Line) int {
-	ocean := make(Ocean)
+	return countOverlaps(lines, Orthogonal)
+}
+
+func part2(lines []Line) int {
+	return countOverlaps(lines, Diagonal)
+}
+
+func part3(lines []Line) int {
+	return countOverlaps(lines, AnySlope)
+}
+
+func countOverlaps(lines []Line, mode Mode) int {
+	if *backend == "sweep" {
+		return SweepOverlaps(lines, mode, *minOverlap)
+	}
+	ocean := MakeOcean(*backend, lines)
+	traceAll(ocean, lines, mode)
+	return ocean.AtLeast(*minOverlap)
+}
 
+func traceAll(ocean Ocean, lines []Line, mode Mode) {
 	for _, line := range lines {
-		line.trace(ocean, false)
+		line.trace(ocean, mode)
+	}
+}
+
+func checkSweep(lines []Line) {
+	for _, mode := range []Mode{Orthogonal, Diagonal} {
+		ocean := make(MapOcean)
+		traceAll(ocean, lines, mode)
+		for k := 1; k <= 4; k++ {
+			expected := ocean.AtLeast(k)
+			if got := SweepOverlaps(lines, mode, k); got != expected {
+				log.Fatalf("Mode %d, k=%d: sweep gave %d, map gave %d", mode, k, got, expected)
+			}
+		}
+		fmt.Println(ocean.AtLeast(*minOverlap))
+	}
+}
+
+func MakeOcean(name string, lines []Line) Ocean {
+	switch name {
+	case "map":
+		return make(MapOcean)
+	case "grid":
+		min, max := Bounds(lines)
+		return MakeGridOcean(min, max)
 	}
+	log.Fatalf("Unknown backend %q", name)
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+type MapOcean map[Point]int
 
+func (ocean MapOcean) Mark(p Point) {
+	ocean[p]++
+}
+
+func (ocean MapOcean) Count(p Point) int {
+	return ocean[p]
+}
+
+func (ocean MapOcean) AtLeast(k int) int {
 	total := 0
 	for _, count := range ocean {
-		if count > 1 {
+		if count >= k {
 			total++
 		}
 	}
-	//fmt.Println(ocean)
+	return total
+}
+
+// A GridOcean is a dense grid covering min to max inclusive.
+type GridOcean struct {
+	min, max Point
+	w        int
+	count    []int
+}
+
+func MakeGridOcean(min, max Point) *GridOcean {
+	w := max.x - min.x + 1
+	h := max.y - min.y + 1
+	return &GridOcean{min: min, max: max, w: w, count: make([]int, w*h)}
+}
+
+func (ocean *GridOcean) index(p Point) (int, bool) {
+	if p.x < ocean.min.x || p.y < ocean.min.y || p.x > ocean.max.x || p.y > ocean.max.y {
+		return -1, false
+	}
+	return (p.y-ocean.min.y)*ocean.w + (p.x - ocean.min.x), true
+}
+
+func (ocean *GridOcean) Mark(p Point) {
+	index, found := ocean.index(p)
+	if !found {
+		log.Fatalf("Point %v is outside the grid", p)
+	}
+	ocean.count[index]++
+}
 
+func (ocean *GridOcean) Count(p Point) int {
+	if index, found := ocean.index(p); found {
+		return ocean.count[index]
+	}
+	return 0
+}
+
+func (ocean *GridOcean) AtLeast(k int) int {
+	total := 0
+	for _, count := range ocean.count {
+		if count >= k {
+			total++
+		}
+	}
 	return total
 }
 
-func part2(lines []Line) int {
-	ocean := make(Ocean)
+// Heatmap draws the ocean the same way as the puzzle description, with '#'
+// for points with more than nine lines.
+func Heatmap(ocean Ocean, min, max Point) string {
+	var b strings.Builder
+	for y := min.y; y <= max.y; y++ {
+		for x := min.x; x <= max.x; x++ {
+			switch count := ocean.Count(Point{x, y}); {
+			case count == 0:
+				b.WriteByte('.')
+			case count <= 9:
+				b.WriteByte(byte('0' + count))
+			default:
+				b.WriteByte('#')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
 
+func Bounds(lines []Line) (Point, Point) {
+	if len(lines) == 0 {
+		return Point{}, Point{}
+	}
+	min, max := lines[0].p[0], lines[0].p[0]
 	for _, line := range lines {
-		line.trace(ocean, true)
+		for _, p := range line.p {
+			if p.x < min.x {
+				min.x = p.x
+			}
+			if p.y < min.y {
+				min.y = p.y
+			}
+			if p.x > max.x {
+				max.x = p.x
+			}
+			if p.y > max.y {
+				max.y = p.y
+			}
+		}
+	}
+	return min, max
+}
+
+//------------------------------------------------------------------------------
+
+// SweepOverlaps counts the points covered by at least k lines without
+// visiting every point, so it copes with huge coordinates. Lines are grouped
+// by the infinite line they lie on, and a 1D sweep along each of those finds
+// the depth of coverage. Points where lines of different directions cross
+// are found by intersecting each pair of infinite lines, and their depth is
+// the total from every line through them. Lines at other than 45 degree
+// slopes are not supported.
+func SweepOverlaps(lines []Line, mode Mode, k int) int {
+	if mode > Diagonal {
+		log.Fatal("The sweep backend only handles orthogonal and diagonal lines")
+	}
+
+	covers := make(map[Carrier][]Run)
+	for carrier, intervals := range groupByCarrier(lines, mode) {
+		covers[carrier] = sweep(intervals)
 	}
 
 	total := 0
-	for _, count := range ocean {
-		if count > 1 {
+	for _, runs := range covers {
+		for _, run := range runs {
+			if run.depth >= k {
+				total += run.end - run.start + 1
+			}
+		}
+	}
+
+	carriers := make([]Carrier, 0, len(covers))
+	for carrier := range covers {
+		carriers = append(carriers, carrier)
+	}
+
+	crossings := make(map[Point]bool)
+	for i, c0 := range carriers {
+		for _, c1 := range carriers[i+1:] {
+			if c0.dir == c1.dir {
+				continue
+			}
+			if p, found := c0.Intersect(c1); found &&
+				depthAt(covers[c0], c0.dir.Param(p)) > 0 &&
+				depthAt(covers[c1], c1.dir.Param(p)) > 0 {
+				crossings[p] = true
+			}
+		}
+	}
+
+	// Replace each crossing's per-line counts with its combined depth.
+	for p := range crossings {
+		depth := 0
+		for dir := Horizontal; dir <= Falling; dir++ {
+			d := depthAt(covers[dir.Through(p)], dir.Param(p))
+			if d >= k {
+				total--
+			}
+			depth += d
+		}
+		if depth >= k {
 			total++
 		}
 	}
-	//fmt.Println(ocean)
 
 	return total
 }
 
-func (line *Line) trace(ocean Ocean, withDiag bool) {
+type Direction int
+
+const (
+	Horizontal Direction = iota // y = c
+	Vertical                    // x = c
+	Rising                      // x - y = c
+	Falling                     // x + y = c
+)
+
+// A Carrier is the infinite line a segment lies on, ax + by = c.
+type Carrier struct {
+	dir Direction
+	c   int
+}
+
+var Coefficients = [...][2]int{{0, 1}, {1, 0}, {1, -1}, {1, 1}}
+
+func (dir Direction) Through(p Point) Carrier {
+	a := Coefficients[dir]
+	return Carrier{dir: dir, c: a[0]*p.x + a[1]*p.y}
+}
+
+// Param gives the position of a point along lines in this direction.
+func (dir Direction) Param(p Point) int {
+	if dir == Vertical {
+		return p.y
+	}
+	return p.x
+}
+
+func (c0 Carrier) Intersect(c1 Carrier) (Point, bool) {
+	a0, a1 := Coefficients[c0.dir], Coefficients[c1.dir]
+	det := a0[0]*a1[1] - a1[0]*a0[1]
+	x := c0.c*a1[1] - c1.c*a0[1]
+	y := a0[0]*c1.c - a1[0]*c0.c
+	if det == 0 || x%det != 0 || y%det != 0 {
+		return Point{}, false
+	}
+	return Point{x / det, y / det}, true
+}
+
+func groupByCarrier(lines []Line, mode Mode) map[Carrier][][2]int {
+	groups := make(map[Carrier][][2]int)
+	for _, line := range lines {
+		if line.Mode() > mode {
+			continue
+		}
+
+		var dir Direction
+		switch {
+		case line.p[0].y == line.p[1].y:
+			dir = Horizontal
+		case line.p[0].x == line.p[1].x:
+			dir = Vertical
+		case (line.p[1].x - line.p[0].x) == (line.p[1].y - line.p[0].y):
+			dir = Rising
+		default:
+			dir = Falling
+		}
+
+		carrier := dir.Through(line.p[0])
+		t0, t1 := dir.Param(line.p[0]), dir.Param(line.p[1])
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		groups[carrier] = append(groups[carrier], [2]int{t0, t1})
+	}
+	return groups
+}
+
+// A Run is a stretch of a carrier, start to end inclusive, covered by depth
+// segments.
+type Run struct {
+	start, end, depth int
+}
+
+// sweep turns inclusive intervals into sorted runs of constant, non-zero depth.
+func sweep(intervals [][2]int) []Run {
+	type Event struct {
+		pos, delta int
+	}
+	events := make([]Event, 0, len(intervals)*2)
+	for _, interval := range intervals {
+		events = append(events, Event{interval[0], 1}, Event{interval[1] + 1, -1})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].pos < events[j].pos })
+
+	runs := make([]Run, 0)
+	depth := 0
+	for i, event := range events {
+		depth += event.delta
+		if i+1 < len(events) && events[i+1].pos == event.pos {
+			continue
+		}
+		if depth > 0 {
+			runs = append(runs, Run{start: event.pos, end: events[i+1].pos - 1, depth: depth})
+		}
+	}
+	return runs
+}
+
+func depthAt(runs []Run, t int) int {
+	i := sort.Search(len(runs), func(i int) bool { return runs[i].end >= t })
+	if i < len(runs) && runs[i].start <= t {
+		return runs[i].depth
+	}
+	return 0
+}
+
+//------------------------------------------------------------------------------
+
+func (line *Line) Mode() Mode {
+	_, nx := signMag(line.p[1].x - line.p[0].x)
+	_, ny := signMag(line.p[1].y - line.p[0].y)
+
+	if nx == 0 || ny == 0 {
+		return Orthogonal
+	}
+	if nx == ny {
+		return Diagonal
+	}
+	return AnySlope
+}
+
+// trace marks every point on the line, using Bresenham's algorithm, if the
+// line is allowed by mode.
+func (line *Line) trace(ocean Ocean, mode Mode) {
+	if line.Mode() > mode {
+		return
+	}
+
 	dx, nx := signMag(line.p[1].x - line.p[0].x)
 	dy, ny := signMag(line.p[1].y - line.p[0].y)
 	p := line.p[0]
 
-	if dy == 0 {
-		for i := 0; i <= nx; i++ {
-			ocean[p]++
-			p.x += dx
+	err := nx - ny
+	for {
+		ocean.Mark(p)
+		if p == line.p[1] {
+			return
 		}
-	} else if dx == 0 {
-		for i := 0; i <= ny; i++ {
-			ocean[p]++
-			p.y += dy
-		}
-	} else if withDiag {
-		for i := 0; i <= nx; i++ {
-			ocean[p]++
+		e2 := 2 * err
+		if e2 >= -ny {
+			err -= ny
 			p.x += dx
+		}
+		if e2 <= nx {
+			err += nx
 			p.y += dy
 		}
 	}
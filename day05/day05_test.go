@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	for _, test := range []struct {
+		line   string
+		points []Point
+	}{
+		{"0,0 -> 3,0", []Point{{0, 0}, {1, 0}, {2, 0}, {3, 0}}},
+		{"1,3 -> 1,1", []Point{{1, 3}, {1, 2}, {1, 1}}},
+		{"2,0 -> 0,2", []Point{{2, 0}, {1, 1}, {0, 2}}},
+		{"0,0 -> 2,1", []Point{{0, 0}, {1, 1}, {2, 1}}},
+		{"0,0 -> 4,1", []Point{{0, 0}, {1, 0}, {2, 1}, {3, 1}, {4, 1}}},
+		{"3,0 -> 0,2", []Point{{3, 0}, {2, 1}, {1, 1}, {0, 2}}},
+		{"0,0 -> 1,3", []Point{{0, 0}, {0, 1}, {1, 2}, {1, 3}}},
+	} {
+		line := parseLine(test.line)
+		for _, name := range []string{"map", "grid"} {
+			ocean := MakeOcean(name, []Line{line})
+			line.trace(ocean, AnySlope)
+
+			if got := ocean.AtLeast(1); got != len(test.points) {
+				t.Errorf("%s on %s: marked %d points, want %d", test.line, name, got, len(test.points))
+			}
+			for _, p := range test.points {
+				if got := ocean.Count(p); got != 1 {
+					t.Errorf("%s on %s: %v marked %d times, want 1", test.line, name, p, got)
+				}
+			}
+		}
+	}
+}
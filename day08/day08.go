@@ -2,15 +2,27 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
 	"log"
+	"math/bits"
 	"sort"
 	"strings"
 )
 
-const SegmentCount = 7
+// A Display describes which segments light up for each glyph. Segments are
+// named 'A', 'B', ... and the scrambled wires 'a', 'b', ...
+type Display struct {
+	segmentCount int
+	glyphs       []string
+	names        []string
 
-var DigitSegments = [...]string{
+	glyphIndex   map[string]int // sorted segments -> glyph
+	signatureMap map[string]int // signature -> segment
+	ambiguous    [][]int        // groups of segments sharing a signature
+}
+
+var DigitSegments = []string{
 	"ABCEFG",  // 0
 	"CF",      // 1
 	"ACDEG",   // 2
@@ -23,21 +35,56 @@ var DigitSegments = [...]string{
 	"ABCDFG",  // 9
 }
 
+var HexSegments = append(append([]string(nil), DigitSegments...),
+	"ABCDEF", // A
+	"BDEFG",  // b
+	"ABEG",   // C
+	"CDEFG",  // d
+	"ABDEG",  // E
+	"ABDE",   // F
+)
+
+var Displays = map[string]func() Display{
+	"digits": func() Display {
+		return MakeDisplay(7, DigitSegments, strings.Split("0123456789", ""))
+	},
+	"hex": func() Display {
+		return MakeDisplay(7, HexSegments, strings.Split("0123456789ABCDEF", ""))
+	},
+}
+
+var displayName = flag.String("display", "digits", "display definition: digits or hex")
+var showWiring = flag.Bool("wiring", false, "print the wiring and decoded output for each line")
+
 func main() {
 	filename := aoc.GetFilename()
 	lines := aoc.GetInputLines(filename)
 
-	fmt.Println(part1(lines))
-	fmt.Println(part2(lines))
+	makeDisplay, found := Displays[*displayName]
+	if !found {
+		log.Fatalf("Unknown display %q", *displayName)
+	}
+	display := makeDisplay()
+	for _, group := range display.ambiguous {
+		fmt.Printf("segments %s share a signature\n", segmentNames(group, 'A'))
+	}
+
+	fmt.Println(part1(display, lines))
+	fmt.Println(part2(display, lines))
 }
 
-func part1(lines []string) int {
+func part1(display Display, lines []string) int {
+	// Glyphs with a unique number of segments can be spotted by length alone.
+	lengths := make(map[int]int)
+	for _, glyph := range display.glyphs {
+		lengths[len(glyph)]++
+	}
+
 	total := 0
 	for _, line := range lines {
 		_, digits := parseLine(line)
 		for _, digit := range digits {
-			length := len(digit)
-			if length == 2 || length == 3 || length == 4 || length == 7 {
+			if lengths[len(digit)] == 1 {
 				total++
 			}
 		}
@@ -46,93 +93,311 @@ func part1(lines []string) int {
 	return total
 }
 
-func part2(lines []string) int {
-	signatureMap := makeSignatureMap(DigitSegments[0:])
-	signalMap := make(map[rune]rune)
-
-	digitMap := make(map[string]int)
-	for digit, segments := range DigitSegments {
-		digitMap[segments] = digit
-	}
-
+func part2(display Display, lines []string) int {
 	total := 0
-	for _, line := range lines {
+	for i, line := range lines {
 		signals, digits := parseLine(line)
 
-		signatures := makeSignatures(signals, 'a')
-		for i, signature := range signatures {
-			signalMap[rune('a'+i)] = signatureMap[signature]
+		decodings, err := display.Decode(signals, digits)
+		if err != nil {
+			log.Fatalf("Line %d: %v", i+1, err)
+		}
+		if *showWiring {
+			for _, decoded := range decodings {
+				fmt.Printf("%s %s\n", decoded.WiringString(), decoded.output)
+			}
 		}
 
-		value := 0
-		for _, digit := range digits {
-			segments := mapDigits(digit, signalMap)
-			value = value*10 + digitMap[segments]
+		for _, decoded := range decodings[1:] {
+			if decoded.value != decodings[0].value {
+				log.Fatalf("Line %d: %d wirings give different outputs", i+1, len(decodings))
+			}
 		}
-		total += value
+		total += decodings[0].value
 	}
 
 	return total
 }
 
-func mapDigits(digits string, signalMap map[rune]rune) string {
-	segments := make([]int, len(digits))
+//------------------------------------------------------------------------------
+
+func MakeDisplay(segmentCount int, glyphs, names []string) Display {
+	if len(glyphs) != len(names) {
+		log.Fatalf("Have %d glyphs but %d names", len(glyphs), len(names))
+	}
+
+	display := Display{
+		segmentCount: segmentCount,
+		glyphs:       glyphs,
+		names:        names,
+		glyphIndex:   make(map[string]int),
+		signatureMap: make(map[string]int),
+		ambiguous:    make([][]int, 0),
+	}
+
+	for i, glyph := range glyphs {
+		for _, segment := range glyph {
+			if segment < 'A' || int(segment-'A') >= segmentCount {
+				log.Fatalf("Glyph %s uses unknown segment %c", names[i], segment)
+			}
+		}
+		key := sortString(glyph)
+		if other, found := display.glyphIndex[key]; found {
+			log.Fatalf("Glyphs %s and %s have the same segments", names[other], names[i])
+		}
+		display.glyphIndex[key] = i
+	}
+
+	shared := make(map[string][]int)
+	for segment, signature := range makeSignatures(glyphs, 'A', segmentCount) {
+		shared[signature] = append(shared[signature], segment)
+		display.signatureMap[signature] = segment
+	}
+	for _, group := range shared {
+		if len(group) > 1 {
+			display.ambiguous = append(display.ambiguous, group)
+		}
+	}
+	sort.Slice(display.ambiguous, func(i, j int) bool {
+		return display.ambiguous[i][0] < display.ambiguous[j][0]
+	})
+
+	return display
+}
+
+// Decoded holds the wiring found for one line: wiring[w] is the segment
+// driven by wire 'a'+w.
+type Decoded struct {
+	wiring []int
+	output string
+	value  int
+}
+
+// Decode returns every wiring consistent with the signal patterns and output
+// digits. If the display's signatures are unique and every glyph was seen,
+// each segment is identified by how many glyphs of each length it appears
+// in. Otherwise it falls back to searching for wirings with Solve.
+func (display *Display) Decode(signals, digits []string) ([]Decoded, error) {
+	if len(display.ambiguous) > 0 || len(signals) != len(display.glyphs) {
+		return display.Solve(signals, digits)
+	}
+
+	wiring := make([]int, display.segmentCount)
+	for wire, signature := range makeSignatures(signals, 'a', display.segmentCount) {
+		segment, found := display.signatureMap[signature]
+		if !found {
+			return display.Solve(signals, digits)
+		}
+		wiring[wire] = segment
+	}
+
+	decoded, err := display.readOutput(wiring, digits)
+	if err != nil {
+		return nil, err
+	}
+	return []Decoded{decoded}, nil
+}
+
+// Solve finds every wiring under which each distinct pattern, from both the
+// signals and the digits, is a different glyph. Wires are assigned to
+// segments one at a time, backtracking as soon as some pattern can no longer
+// become a glyph.
+func (display *Display) Solve(signals, digits []string) ([]Decoded, error) {
+	n := display.segmentCount
+
+	glyphMasks := make([]uint, len(display.glyphs))
+	for i, glyph := range display.glyphs {
+		glyphMasks[i] = segmentMask(glyph, 'A')
+	}
+
+	seen := make(map[uint]bool)
+	patterns := make([]uint, 0)
+	for _, pattern := range append(append([]string(nil), signals...), digits...) {
+		for _, wire := range pattern {
+			if wire < 'a' || int(wire-'a') >= n {
+				return nil, fmt.Errorf("pattern %s uses unknown wire %c", pattern, wire)
+			}
+		}
+		mask := segmentMask(pattern, 'a')
+		if !seen[mask] {
+			seen[mask] = true
+			patterns = append(patterns, mask)
+		}
+	}
 
-	for i, digit := range digits {
-		segments[i] = int(signalMap[digit])
+	// A pattern is still possible if some glyph of the same size contains
+	// every segment its wires map to so far, and no other used segment.
+	possible := func(pattern, mapped, used uint) bool {
+		for _, glyph := range glyphMasks {
+			if bits.OnesCount(glyph) == bits.OnesCount(pattern) &&
+				glyph&mapped == mapped && glyph&used == mapped {
+				return true
+			}
+		}
+		return false
 	}
 
-	sort.Sort(sort.IntSlice(segments))
-	var digit strings.Builder
+	wiring := make([]int, n)
+	found := make([]Decoded, 0)
+
+	var search func(wire int, used uint)
+	search = func(wire int, used uint) {
+		for _, pattern := range patterns {
+			var mapped uint
+			for w := 0; w < wire; w++ {
+				if pattern&(1<<w) != 0 {
+					mapped |= 1 << wiring[w]
+				}
+			}
+			if !possible(pattern, mapped, used) {
+				return
+			}
+		}
+
+		if wire == n {
+			if display.distinctGlyphs(patterns, wiring) {
+				if decoded, err := display.readOutput(append([]int(nil), wiring...), digits); err == nil {
+					found = append(found, decoded)
+				}
+			}
+			return
+		}
+
+		for segment := 0; segment < n; segment++ {
+			if used&(1<<segment) == 0 {
+				wiring[wire] = segment
+				search(wire+1, used|1<<segment)
+			}
+		}
+	}
+	search(0, 0)
+
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no wiring is consistent with the patterns")
+	}
+	return found, nil
+}
+
+func (display *Display) distinctGlyphs(patterns []uint, wiring []int) bool {
+	glyphs := make(map[int]bool)
+	for _, pattern := range patterns {
+		var segments strings.Builder
+		for w := range wiring {
+			if pattern&(1<<w) != 0 {
+				segments.WriteRune(rune('A' + wiring[w]))
+			}
+		}
+		glyph, found := display.glyphIndex[sortString(segments.String())]
+		if !found || glyphs[glyph] {
+			return false
+		}
+		glyphs[glyph] = true
+	}
+	return true
+}
+
+func segmentMask(segments string, base rune) uint {
+	var mask uint
 	for _, segment := range segments {
-		digit.WriteRune(rune(segment))
+		mask |= 1 << uint(segment-base)
+	}
+	return mask
+}
+
+func (display *Display) readOutput(wiring []int, digits []string) (Decoded, error) {
+	decoded := Decoded{wiring: wiring}
+
+	var output strings.Builder
+	for _, digit := range digits {
+		glyph, found := display.glyphIndex[mapDigits(digit, wiring)]
+		if !found {
+			return Decoded{}, fmt.Errorf("output %s is not a glyph", digit)
+		}
+		output.WriteString(display.names[glyph])
+		decoded.value = decoded.value*len(display.glyphs) + glyph
+	}
+	decoded.output = output.String()
+
+	return decoded, nil
+}
+
+func (decoded Decoded) WiringString() string {
+	var b strings.Builder
+	for wire, segment := range decoded.wiring {
+		if wire > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%c=%c", 'a'+wire, 'A'+segment)
 	}
+	return b.String()
+}
+
+func mapDigits(digits string, wiring []int) string {
+	segments := make([]rune, len(digits))
+
+	for i, wire := range digits {
+		if wire < 'a' || int(wire-'a') >= len(wiring) {
+			return "" // not a glyph
+		}
+		segments[i] = rune('A' + wiring[wire-'a'])
+	}
+
+	return sortString(string(segments))
+}
 
-	return digit.String()
+func sortString(in string) string {
+	runes := []rune(in)
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	return string(runes)
 }
 
-func makeSignatureMap(signals []string) map[string]rune {
-	signatureMap := make(map[string]rune)
-	for i, signature := range makeSignatures(signals, 'A') {
-		signatureMap[signature] = rune('A' + i)
+func segmentNames(segments []int, base rune) string {
+	names := make([]rune, len(segments))
+	for i, segment := range segments {
+		names[i] = base + rune(segment)
 	}
-	return signatureMap
+	return string(names)
 }
 
-func makeSignatures(signals []string, base rune) []string {
-	var counts [SegmentCount][SegmentCount + 1]int
+func makeSignatures(signals []string, base rune, segmentCount int) []string {
+	counts := make([][]int, segmentCount)
+	for i := range counts {
+		counts[i] = make([]int, segmentCount+1)
+	}
 	for _, segments := range signals {
 		length := len(segments)
 		for _, segment := range segments {
-			counts[segment-base][length]++
+			index := int(segment - base)
+			if index < 0 || index >= segmentCount || length > segmentCount {
+				continue // such a pattern can't match any glyph
+			}
+			counts[index][length]++
 		}
 	}
 
-	signatures := make([]string, SegmentCount)
+	signatures := make([]string, segmentCount)
 	for i, count := range counts {
-		signatures[i] = makeSignature(count[0:])
+		signatures[i] = makeSignature(count)
 	}
 
 	return signatures
-
 }
 
 func makeSignature(counts []int) string {
-	var out strings.Builder
-	for _, count := range counts {
-		out.WriteRune(rune('0' + count))
+	words := make([]string, len(counts))
+	for i, count := range counts {
+		words[i] = fmt.Sprint(count)
 	}
-	return out.String()
+	return strings.Join(words, ",")
 }
 
+// parseLine splits a line into signal patterns and output digits, either
+// side of the '|'.
 func parseLine(line string) ([]string, []string) {
-	words := strings.Split(line, " ")
-	if len(words) != 15 {
-		log.Fatal("Expecting 15 words in input, got ", len(words))
+	parts := strings.Split(line, "|")
+	if len(parts) != 2 {
+		log.Fatal("Expecting signals | digits, got ", line)
 	}
 
-	signals := words[0:10] // ten signal patterns
-	digits := words[11:15] // four output digits
-
-	return signals, digits
+	return strings.Fields(parts[0]), strings.Fields(parts[1])
 }
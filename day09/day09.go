@@ -2,8 +2,10 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
 	"sort"
+	"strings"
 )
 
 type HeightMap struct {
@@ -11,12 +13,40 @@ type HeightMap struct {
 	height     []int
 }
 
+type Point struct {
+	row, col int
+}
+
+// A Basin is every location which flows down to the same low point. The
+// boundary is the part of the basin next to a height 9 or the edge of the map.
+type Basin struct {
+	label    int
+	size     int
+	lowPoint Point
+	boundary []Point
+}
+
+// Labels holds the basin label for every location, or NoBasin for height 9.
+type Labels struct {
+	rows, cols int
+	label      []int
+}
+
+const NoBasin = -1
+
+var render = flag.Bool("render", false, "print the map with each basin in its own colour")
+
 func main() {
 	filename := aoc.GetFilename()
 	heightMap := parseHeightMap(filename)
 
 	fmt.Println(part1(&heightMap))
 	fmt.Println(part2(&heightMap))
+
+	if *render {
+		labels, _ := heightMap.LabelBasins()
+		fmt.Print(heightMap.Render(labels))
+	}
 }
 
 func part1(h *HeightMap) int {
@@ -30,13 +60,14 @@ func part1(h *HeightMap) int {
 }
 
 func part2(h *HeightMap) int {
-	sizes := make([]int, 0)
+	_, basins := h.LabelBasins()
 
-	for row := 0; row < h.rows; row++ {
-		for col := 0; col < h.cols; col++ {
-			sizes = append(sizes, h.FloodFill(row, col))
-		}
+	// Pad with empty basins, in case there are fewer than three.
+	sizes := make([]int, len(basins), len(basins)+3)
+	for i, basin := range basins {
+		sizes[i] = basin.size
 	}
+	sizes = append(sizes, 0, 0, 0)
 
 	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
 
@@ -82,13 +113,85 @@ func (h *HeightMap) RiskLevel(row, col int) int {
 	return h.Height(row, col) + 1
 }
 
-func (h *HeightMap) FloodFill(row, col int) int {
-	if h.Height(row, col) == 9 {
-		return 0
+// LabelBasins flood fills each basin in turn, without changing the map.
+func (h *HeightMap) LabelBasins() (Labels, []Basin) {
+	labels := Labels{rows: h.rows, cols: h.cols, label: make([]int, len(h.height))}
+	for i := range labels.label {
+		labels.label[i] = NoBasin
 	}
 
-	index := row*h.cols + col
-	h.height[index] = 9
+	basins := make([]Basin, 0)
+	for row := 0; row < h.rows; row++ {
+		for col := 0; col < h.cols; col++ {
+			index := row*h.cols + col
+			if h.height[index] != 9 && labels.label[index] == NoBasin {
+				basins = append(basins, h.floodFill(&labels, Point{row, col}, len(basins)))
+			}
+		}
+	}
+
+	return labels, basins
+}
+
+var Directions = [...]Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+
+func (h *HeightMap) floodFill(labels *Labels, start Point, label int) Basin {
+	basin := Basin{label: label, lowPoint: start, boundary: make([]Point, 0)}
+
+	labels.label[start.row*h.cols+start.col] = label
+	stack := []Point{start}
+
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		basin.size++
+		if h.Height(p.row, p.col) < h.Height(basin.lowPoint.row, basin.lowPoint.col) {
+			basin.lowPoint = p
+		}
+
+		onBoundary := false
+		for _, d := range Directions {
+			q := Point{p.row + d.row, p.col + d.col}
+			if h.Height(q.row, q.col) == 9 {
+				onBoundary = true
+				continue
+			}
+
+			index := q.row*h.cols + q.col
+			if labels.label[index] == NoBasin {
+				labels.label[index] = label
+				stack = append(stack, q)
+			}
+		}
+		if onBoundary {
+			basin.boundary = append(basin.boundary, p)
+		}
+	}
+
+	return basin
+}
+
+var BasinColours = [...]int{31, 32, 33, 34, 35, 36, 91, 92, 93, 94, 95, 96}
+
+// Render shows the heights, with each basin coloured using ANSI escapes.
+func (h *HeightMap) Render(labels Labels) string {
+	var b strings.Builder
+
+	index := 0
+	for row := 0; row < h.rows; row++ {
+		for col := 0; col < h.cols; col++ {
+			height := h.height[index]
+			if label := labels.label[index]; label == NoBasin {
+				fmt.Fprintf(&b, "%d", height)
+			} else {
+				colour := BasinColours[label%len(BasinColours)]
+				fmt.Fprintf(&b, "\x1b[%dm%d\x1b[0m", colour, height)
+			}
+			index++
+		}
+		b.WriteByte('\n')
+	}
 
-	return 1 + h.FloodFill(row-1, col) + h.FloodFill(row+1, col) + h.FloodFill(row, col-1) + h.FloodFill(row, col+1)
+	return b.String()
 }
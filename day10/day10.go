@@ -2,130 +2,213 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
+	"log"
 	"sort"
+	"strings"
 )
 
+var pairs = flag.String("pairs", "()[]{}<>", "bracket pairs, each opener followed by its closer")
+var syntaxScores = flag.String("syntax-scores", "3,57,1197,25137", "score for an illegal closer of each pair")
+var completeScores = flag.String("complete-scores", "1,2,3,4", "autocomplete score for each pair")
+var lint = flag.Bool("lint", false, "report the status of every line")
+var fix = flag.Bool("fix", false, "print every line with errors fixed and autocompletion appended")
+
 func main() {
 	filename := aoc.GetFilename()
 	lines := aoc.GetInputLines(filename)
 
-	fmt.Println(part1(lines))
-	fmt.Println(part2(lines))
+	language := MakeLanguage(*pairs,
+		aoc.ParseInts(strings.Split(*syntaxScores, ",")),
+		aoc.ParseInts(strings.Split(*completeScores, ",")))
+
+	fmt.Println(part1(language, lines))
+	fmt.Println(part2(language, lines))
+
+	for i, line := range lines {
+		if *lint {
+			fmt.Printf("%d: %s\n", i+1, language.Check(line))
+		}
+		if *fix {
+			fmt.Println(language.Fix(line))
+		}
+	}
 }
 
-func part1(lines []string) int {
+func part1(language Language, lines []string) int {
 	total := 0
 	for _, line := range lines {
-		stack := make([]rune, 0)
-		count := 0
-		for _, symbol := range line {
-			if isOpen(symbol) {
-				stack = append(stack, symbol)
-				count++
-				continue
-			}
-
-			if count == 0 {
-				break // incomplete
-			}
-
-			match := stack[count-1]
-			if !isMatch(match, symbol) {
-				total += syntaxScore(symbol)
-				break // corrupted
-			}
-			stack = stack[:count-1]
-			count--
+		if result := language.Check(line); result.status == Corrupted {
+			total += language.SyntaxScore(result.found)
 		}
 	}
 	return total
 }
 
-func part2(lines []string) int {
+func part2(language Language, lines []string) int {
 	scores := make([]int, 0)
 	for _, line := range lines {
-		stack := make([]rune, 0)
-		count := 0
-		corrupted := false
-		for _, symbol := range line {
-			if isOpen(symbol) {
-				stack = append(stack, symbol)
-				count++
-				continue
-			}
-
-			if count == 0 {
-				corrupted = true
-				break // incomplete
-			}
-
-			match := stack[count-1]
-			if !isMatch(match, symbol) {
-				corrupted = true
-				break // corrupted
-			}
-			stack = stack[:count-1]
-			count--
+		if result := language.Check(line); result.status == Incomplete {
+			scores = append(scores, language.AutocompleteScore(result.completion))
 		}
+	}
+	if len(scores) == 0 {
+		return 0
+	}
+	sort.Sort(sort.IntSlice(scores))
+	return scores[len(scores)/2]
+}
 
-		if corrupted {
-			continue
+//------------------------------------------------------------------------------
+
+type Pair struct {
+	open, close   rune
+	syntaxScore   int
+	completeScore int
+}
+
+type Language struct {
+	pairs   []Pair
+	opening map[rune]int // opener -> pair index
+	closing map[rune]int // closer -> pair index
+	base    int          // autocomplete scores are read in this base
+}
+
+func MakeLanguage(brackets string, syntaxScores, completeScores []int) Language {
+	runes := []rune(brackets)
+	if len(runes)%2 != 0 {
+		log.Fatalf("Bracket pairs %q must have an even number of characters", brackets)
+	}
+	count := len(runes) / 2
+	if len(syntaxScores) != count || len(completeScores) != count {
+		log.Fatalf("Need %d syntax and autocomplete scores, got %d and %d",
+			count, len(syntaxScores), len(completeScores))
+	}
+
+	language := Language{
+		pairs:   make([]Pair, count),
+		opening: make(map[rune]int),
+		closing: make(map[rune]int),
+	}
+	for i := 0; i < count; i++ {
+		open, close := runes[i*2], runes[i*2+1]
+		_, openSeen := language.opening[open]
+		_, closeSeen := language.closing[close]
+		if open == close || openSeen || closeSeen {
+			log.Fatalf("Bracket %c%c clashes with another pair", open, close)
+		}
+		if completeScores[i] < 1 {
+			log.Fatalf("Autocomplete score for %c%c must be positive, got %d", open, close, completeScores[i])
 		}
+		if completeScores[i] >= language.base {
+			language.base = completeScores[i] + 1
+		}
+		language.pairs[i] = Pair{open, close, syntaxScores[i], completeScores[i]}
+		language.opening[open] = i
+		language.closing[close] = i
+	}
+	return language
+}
+
+type Status int
+
+const (
+	Valid Status = iota
+	Corrupted
+	Incomplete
+)
 
-		score := 0
-		for i := count - 1; i >= 0; i-- {
-			score = score*5 + autocompleteScore(stack[i])
+var StatusNames = [...]string{"valid", "corrupted", "incomplete"}
+
+// A Result describes the first error in a line. For a corrupted line, pos is
+// the index of the illegal character found, and expected is the closer which
+// should have been there, or zero if nothing was open. For an incomplete
+// line, completion closes every open bracket.
+type Result struct {
+	status     Status
+	pos        int
+	found      rune
+	expected   rune
+	completion string
+}
+
+func (this Result) String() string {
+	switch this.status {
+	case Corrupted:
+		if this.expected == 0 {
+			return fmt.Sprintf("corrupted at %d: unexpected %c", this.pos, this.found)
 		}
-		scores = append(scores, score)
+		return fmt.Sprintf("corrupted at %d: expected %c, found %c", this.pos, this.expected, this.found)
+	case Incomplete:
+		return fmt.Sprintf("incomplete: complete with %s", this.completion)
 	}
-	sort.Sort(sort.IntSlice(scores))
-	return scores[len(scores)/2]
+	return StatusNames[this.status]
 }
 
-func isOpen(symbol rune) bool {
-	switch symbol {
-	case '{', '<', '[', '(':
-		return true
+func (language *Language) Check(line string) Result {
+	stack := make([]int, 0)
 
-	default:
-		return false
+	for pos, symbol := range []rune(line) {
+		if pair, found := language.opening[symbol]; found {
+			stack = append(stack, pair)
+			continue
+		}
+
+		result := Result{status: Corrupted, pos: pos, found: symbol}
+		if len(stack) > 0 {
+			result.expected = language.pairs[stack[len(stack)-1]].close
+		}
+		if pair, found := language.closing[symbol]; !found || len(stack) == 0 || stack[len(stack)-1] != pair {
+			return result
+		}
+		stack = stack[:len(stack)-1]
 	}
+
+	if len(stack) == 0 {
+		return Result{status: Valid, pos: len(line)}
+	}
+
+	var completion strings.Builder
+	for i := len(stack) - 1; i >= 0; i-- {
+		completion.WriteRune(language.pairs[stack[i]].close)
+	}
+	return Result{status: Incomplete, pos: len(line), completion: completion.String()}
 }
 
-func isMatch(a, b rune) bool {
-	return (a == '{' && b == '}') ||
-		(a == '<' && b == '>') ||
-		(a == '[' && b == ']') ||
-		(a == '(' && b == ')')
+// Fix replaces each illegal character with the closer that was expected,
+// or drops it if nothing was open, and then appends the autocompletion.
+func (language *Language) Fix(line string) string {
+	runes := []rune(line)
+	for {
+		result := language.Check(string(runes))
+		switch {
+		case result.status == Incomplete:
+			return string(runes) + result.completion
+		case result.status == Valid:
+			return string(runes)
+		case result.expected == 0:
+			runes = append(runes[:result.pos], runes[result.pos+1:]...)
+		default:
+			runes[result.pos] = result.expected
+		}
+	}
 }
 
-func syntaxScore(symbol rune) int {
-	switch symbol {
-	case ')':
-		return 3
-	case ']':
-		return 57
-	case '}':
-		return 1197
-	case '>':
-		return 25137
-	default:
-		return 0
+func (language *Language) SyntaxScore(symbol rune) int {
+	if pair, found := language.closing[symbol]; found {
+		return language.pairs[pair].syntaxScore
 	}
+	return 0
 }
 
-func autocompleteScore(symbol rune) int {
-	switch symbol {
-	case '(':
-		return 1
-	case '[':
-		return 2
-	case '{':
-		return 3
-	case '<':
-		return 4
-	default:
-		return 0
+// AutocompleteScore reads the completion as a number with one digit per
+// closer. The base is one more than the biggest score, so different
+// completions never score the same; with the puzzle's scores it is 5.
+func (language *Language) AutocompleteScore(completion string) int {
+	score := 0
+	for _, symbol := range completion {
+		score = score*language.base + language.pairs[language.closing[symbol]].completeScore
 	}
+	return score
 }
@@ -2,31 +2,75 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 )
 
 type Grid struct {
 	rows, cols int
 	energy     []int
+	flashed    []bool // this step
+	options    Options
+	step       int
+	observers  []Observer
 }
 
 type Point struct {
 	row, col int
 }
 
+// Options change the rules of the simulation. An octopus flashes when its
+// energy goes above threshold, and with wrap set the neighbours of an edge
+// octopus include those on the opposite edge.
+type Options struct {
+	threshold int
+	wrap      bool
+}
+
+var DefaultOptions = Options{threshold: 9, wrap: false}
+
+// A Flash is one octopus flashing. Wave zero is the octopuses which flashed
+// from the step's own energy increase, wave one those set off by wave zero,
+// and so on.
+type Flash struct {
+	point Point
+	wave  int
+}
+
+// A StepEvent lists every flash in a step, in cascade order.
+type StepEvent struct {
+	step    int
+	flashes []Flash
+}
+
+type Observer interface {
+	StepDone(grid *Grid, event StepEvent)
+}
+
+var threshold = flag.Int("threshold", DefaultOptions.threshold, "an octopus flashes when its energy goes above this")
+var wrap = flag.Bool("wrap", DefaultOptions.wrap, "wrap the grid around at the edges")
+var trace = flag.Bool("trace", false, "print the grid and the flashes after every step")
+
 func main() {
 	filename := aoc.GetFilename()
+	options := Options{threshold: *threshold, wrap: *wrap}
+
+	var observers []Observer
+	if *trace {
+		observers = append(observers, Tracer{os.Stdout})
+	}
 
-	fmt.Println(part1(parseGrid(filename), 100))
-	fmt.Println(part2(parseGrid(filename)))
+	fmt.Println(part1(parseGrid(filename, options, observers...), 100))
+	fmt.Println(part2(parseGrid(filename, options, observers...)))
 }
 
 func part1(g Grid, steps int) int {
-	//g.print()
 	flashes := 0
 	for step := 0; step < steps; step++ {
 		flashes += g.doStep()
-		//g.print()
 	}
 	return flashes
 }
@@ -41,42 +85,70 @@ func part2(g Grid) int {
 	}
 }
 
+func (g *Grid) AddObserver(observer Observer) {
+	g.observers = append(g.observers, observer)
+}
+
 func (g *Grid) doStep() int {
-	flashing := 0
+	g.step++
+	flashes := make([]Flash, 0)
+	if g.flashed == nil {
+		g.flashed = make([]bool, len(g.energy))
+	}
 
 	p := Point{row: 0, col: 0}
 	for ; p.row < g.rows; p.row++ {
 		for p.col = 0; p.col < g.cols; p.col++ {
-			flashing += g.increaseEnergy(&p)
+			if g.increaseEnergy(p) {
+				flashes = append(flashes, Flash{point: p, wave: 0})
+			}
 		}
 	}
 
-	i := 0
-	p = Point{row: 0, col: 0}
-	for ; p.row < g.rows; p.row++ {
-		for p.col = 0; p.col < g.cols; p.col++ {
-			if g.energy[i] > 9 {
-				g.energy[i] = 0
+	// The flashes slice doubles as the queue of octopuses whose neighbours
+	// still need their energy increased.
+	for next := 0; next < len(flashes); next++ {
+		flash := flashes[next]
+		for _, neighbour := range g.neighbours(flash.point) {
+			if g.increaseEnergy(neighbour) {
+				flashes = append(flashes, Flash{point: neighbour, wave: flash.wave + 1})
 			}
-			i++
 		}
 	}
 
-	return flashing
+	for _, flash := range flashes {
+		index, _ := g.index(flash.point)
+		g.energy[index] = 0
+		g.flashed[index] = false
+	}
+
+	event := StepEvent{step: g.step, flashes: flashes}
+	for _, observer := range g.observers {
+		observer.StepDone(g, event)
+	}
+
+	return len(flashes)
 }
 
-func (g *Grid) increaseEnergy(p *Point) int {
+// increaseEnergy returns true if the octopus has just started flashing.
+// Each octopus flashes at most once a step, however far above the threshold
+// its energy goes.
+func (g *Grid) increaseEnergy(p Point) bool {
 	index, onGrid := g.index(p)
 	if !onGrid {
-		return 0
+		return false
 	}
 
 	g.energy[index]++
-	if g.energy[index] != 10 {
-		return 0
+	if g.energy[index] > g.options.threshold && !g.flashed[index] {
+		g.flashed[index] = true
+		return true
 	}
+	return false
+}
 
-	total := 1
+func (g *Grid) neighbours(p Point) []Point {
+	out := make([]Point, 0, 8)
 	for drow := -1; drow <= 1; drow++ {
 		for dcol := -1; dcol <= 1; dcol++ {
 			if drow == 0 && dcol == 0 {
@@ -84,40 +156,63 @@ func (g *Grid) increaseEnergy(p *Point) int {
 			}
 
 			neighbour := Point{row: p.row + drow, col: p.col + dcol}
-			total += g.increaseEnergy(&neighbour)
+			if g.options.wrap {
+				neighbour.row = (neighbour.row + g.rows) % g.rows
+				neighbour.col = (neighbour.col + g.cols) % g.cols
+			}
+			out = append(out, neighbour)
 		}
 	}
-	return total
+	return out
 }
 
-func (g *Grid) index(p *Point) (int, bool) {
+func (g *Grid) index(p Point) (int, bool) {
 	if p.row < 0 || p.col < 0 || p.row >= g.rows || p.col >= g.cols {
 		return -1, false
 	}
 	return g.cols*p.row + p.col, true
 }
 
-func (g *Grid) print() {
+// String shows flashing octopuses as '*'. Energy levels above 9 are shown
+// as letters.
+func (g *Grid) String() string {
+	var b strings.Builder
 	i := 0
 	for row := 0; row < g.rows; row++ {
 		for col := 0; col < g.cols; col++ {
-			if g.energy[i] == 0 {
-				fmt.Printf("*")
-			} else {
-				fmt.Printf("%d", g.energy[i])
+			switch energy := g.energy[i]; {
+			case energy == 0:
+				b.WriteByte('*')
+			case energy < 10:
+				b.WriteByte(byte('0' + energy))
+			default:
+				b.WriteByte(byte('a' + energy - 10))
 			}
 			i++
 		}
-		fmt.Printf("\n")
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// A Tracer writes the grid and the flashes after every step.
+type Tracer struct {
+	out io.Writer
+}
+
+func (t Tracer) StepDone(g *Grid, event StepEvent) {
+	fmt.Fprintf(t.out, "After step %d, %d flashes:\n%s", event.step, len(event.flashes), g)
+	for _, flash := range event.flashes {
+		fmt.Fprintf(t.out, " %d:%d,%d", flash.wave, flash.point.row, flash.point.col)
 	}
-	fmt.Printf("\n")
+	fmt.Fprintf(t.out, "\n\n")
 }
 
-func parseGrid(filename string) Grid {
+func parseGrid(filename string, options Options, observers ...Observer) Grid {
 	lines := aoc.GetInputLines(filename)
 	rows := len(lines)
 	cols := len(lines[0])
-	grid := Grid{rows: rows, cols: cols, energy: make([]int, rows*cols)}
+	grid := Grid{rows: rows, cols: cols, energy: make([]int, rows*cols), options: options}
 
 	index := 0
 	for _, line := range lines {
@@ -127,5 +222,9 @@ func parseGrid(filename string) Grid {
 		}
 	}
 
+	for _, observer := range observers {
+		grid.AddObserver(observer)
+	}
+
 	return grid
 }
@@ -2,114 +2,494 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
+	"log"
+	"math/bits"
+	"math/rand"
+	"os"
 	"strings"
 )
 
 const (
-	Start     = 0
-	End       = 1
-	Double    = 2
-	FirstCave = 3
+	Start = 0
+	End   = 1
 )
 
-type Cave int32
+type Cave int
 
 type Caves struct {
 	to      [][]Cave
-	isLarge Cave
+	isLarge []bool
+	names   []string
 }
 
-func MakeCaves(count int, isLarge Cave) Caves {
-	caves := Caves{to: make([][]Cave, count), isLarge: isLarge}
-	for i := 0; i < count; i++ {
+func MakeCaves(names []string) Caves {
+	count := len(names)
+	caves := Caves{
+		to:      make([][]Cave, count),
+		isLarge: make([]bool, count),
+		names:   names,
+	}
+	for i, name := range names {
 		caves.to[i] = make([]Cave, 0)
+		caves.isLarge[i] = strings.ToUpper(name) == name
 	}
 	return caves
 }
 
+type Bitset []uint64
+
+func MakeBitset(size int) Bitset {
+	return make(Bitset, (size+63)/64)
+}
+
+func (this Bitset) Set(cave Cave) {
+	this[cave>>6] |= 1 << (cave & 63)
+}
+
+func (this Bitset) Has(cave Cave) bool {
+	return this[cave>>6]&(1<<(cave&63)) != 0
+}
+
+func (this Bitset) Clear(cave Cave) {
+	this[cave>>6] &^= 1 << (cave & 63)
+}
+
+func (this Bitset) AppendBytes(out []byte) []byte {
+	for _, word := range this {
+		for i := 0; i < 64; i += 8 {
+			out = append(out, byte(word>>i))
+		}
+	}
+	return out
+}
+
 type CaveMap struct {
 	mapping map[string]Cave
-	next    Cave
+	names   []string
 }
 
 func MakeCaveMap() CaveMap {
-	cavemap := CaveMap{mapping: make(map[string]Cave), next: FirstCave}
-	cavemap.mapping["start"] = Start
-	cavemap.mapping["end"] = End
+	cavemap := CaveMap{mapping: make(map[string]Cave), names: make([]string, 0)}
+	cavemap.AssignValue("start")
+	cavemap.AssignValue("end")
 	return cavemap
 }
 
+// A Path is the state of a walk through the caves. Rather than copying it
+// at each step, Extend and Retract update it in place.
 type Path struct {
-	seen int
-	from Cave
+	caves   *Caves
+	route   []Cave
+	visits  []int // visits to each cave so far
+	doubles int   // small caves visited twice
+
+	once, twice Bitset // small caves visited once and twice
+	key         []byte // buffer for Key
 }
 
-func MakePath() Path {
-	return Path{seen: 0, from: Start}
+func MakePath(caves *Caves) Path {
+	n := len(caves.to)
+	once := MakeBitset(n)
+	return Path{
+		caves:   caves,
+		route:   []Cave{Start},
+		visits:  make([]int, n),
+		doubles: 0,
+		once:    once,
+		twice:   MakeBitset(n),
+		key:     make([]byte, 0, 16*len(once)+8),
+	}
 }
 
+var twice = flag.Int("twice", 1, "how many small caves part 2 may visit twice")
+var list = flag.Bool("list", false, "list every part 2 path")
+var bench = flag.Bool("bench", false, "benchmark plain against memoised path counting")
+var generate = flag.Int("generate", 0, "write a random cave system with this many small caves to the input file")
+var large = flag.Int("large", -1, "large caves in a generated system, default a quarter of the small ones")
+var edges = flag.Int("edges", -1, "passages in a generated system, default one and a half times the small caves")
+var seed = flag.Int64("seed", 1, "random seed for -generate")
+
 func main() {
-	caves := parseCaves(aoc.GetFilename())
+	filename := aoc.GetFilename()
+
+	if *generate > 0 {
+		rng := rand.New(rand.NewSource(*seed))
+		lines := RandomCaves(*generate, *large, *edges, rng)
+		aoc.CheckErr(os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+		return
+	}
+
+	caves := parseCaves(filename)
+
+	if *bench {
+		for _, k := range []int{0, *twice} {
+			if Walk(&caves, k, nil) != CountPaths(&caves, k) {
+				log.Fatal("Plain and memoised counts disagree")
+			}
+			aoc.Benchmark(fmt.Sprintf("plain k=%d", k), func() { Walk(&caves, k, nil) })
+			aoc.Benchmark(fmt.Sprintf("memoised k=%d", k), func() { CountPaths(&caves, k) })
+			counter := MakeCounter(&caves, k)
+			counter.Count()
+			fmt.Printf("%-24s %d states, %d hits\n", "", counter.States(), counter.Hits())
+		}
+		return
+	}
 
 	fmt.Println(part1(&caves))
-	fmt.Println(part2(&caves))
+	fmt.Println(part2(&caves, *twice))
+
+	if *list {
+		Walk(&caves, *twice, func(route []Cave) {
+			fmt.Println(caves.RouteString(route))
+		})
+	}
 }
 
 func part1(caves *Caves) int {
-	return walk1(MakePath(), caves)
+	return CountPaths(caves, 0)
+}
+
+func part2(caves *Caves, k int) int {
+	return CountPaths(caves, k)
+}
+
+// Walk counts the paths from start to end which visit small caves at most
+// once, except that up to k of them may be visited twice. If visit is not
+// nil, it is called with the route of each path, which is only valid until
+// visit returns.
+func Walk(caves *Caves, k int, visit func(route []Cave)) int {
+	path := MakePath(caves)
+	return walk(&path, caves, k, visit)
 }
 
-func walk1(path Path, caves *Caves) int {
+func walk(path *Path, caves *Caves, k int, visit func(route []Cave)) int {
 	paths := 0
 
-	for _, to := range caves.To(path.from) {
+	for _, to := range caves.To(path.From()) {
 		if to == End {
 			paths++
-		} else if caves.IsLarge(to) || !path.Seen(to) {
-			next := path.Extend(to)
-			paths += walk1(next, caves)
+			if visit != nil {
+				visit(append(path.route, End))
+			}
+		} else if caves.IsLarge(to) || path.visits[to] == 0 ||
+			(path.visits[to] == 1 && path.doubles < k) {
+			path.Extend(to)
+			paths += walk(path, caves, k, visit)
+			path.Retract()
 		}
 	}
 	return paths
 }
 
-func part2(caves *Caves) int {
-	return walk2(MakePath(), caves)
+// CountPaths gives the same answer as Walk, but remembers how many ways
+// there are to finish from each state. See Counter.
+func CountPaths(caves *Caves, k int) int {
+	counter := MakeCounter(caves, k)
+	return counter.Count()
 }
 
-func walk2(path Path, caves *Caves) int {
-	paths := 0
+// A Counter counts paths in two phases. While fewer than k small caves have
+// been visited twice, the state is the current cave and the visits to small
+// caves, as in Path.Key. After that no visited small cave can be entered
+// again, so the rest of the path is a simple path to the end through Links.
+// Any such path lies in the block (biconnected component) holding the
+// current cave and the end, joined by an imaginary passage, so that block
+// and the current cave are the state. Many different visits leave the same
+// block, which is what makes memoisation pay off on larger cave systems.
+type Counter struct {
+	caves *Caves
+	links Links
+	k     int
+	path  Path
+
+	memo, simple map[string]int
+	hits         int
+
+	// Buffers for findBlock.
+	time  int
+	disc  []int
+	seen  Bitset
+	block Bitset
+	stack []Cave
+	key   []byte
+}
+
+func MakeCounter(caves *Caves, k int) Counter {
+	n := len(caves.to)
+	return Counter{
+		caves:  caves,
+		links:  MakeLinks(caves),
+		k:      k,
+		path:   MakePath(caves),
+		memo:   make(map[string]int),
+		simple: make(map[string]int),
+		disc:   make([]int, n),
+		seen:   MakeBitset(n),
+		block:  MakeBitset(n),
+		stack:  make([]Cave, 0, n),
+		key:    make([]byte, 0, 8*len(MakeBitset(n))+8),
+	}
+}
+
+func (this *Counter) Count() int {
+	return this.countPaths()
+}
+
+// States is how many states have been remembered.
+func (this *Counter) States() int {
+	return len(this.memo) + len(this.simple)
+}
+
+// Hits is how many times a remembered state was found again.
+func (this *Counter) Hits() int {
+	return this.hits
+}
+
+func (this *Counter) countPaths() int {
+	path := &this.path
+	if path.doubles >= this.k {
+		return this.countSimple()
+	}
+
+	key := path.Key()
+	if paths, found := this.memo[string(key)]; found {
+		this.hits++
+		return paths
+	}
+	// The key buffer is about to be reused, so copy it to store the answer.
+	stored := string(key)
 
-	for _, to := range caves.To(path.from) {
+	paths := 0
+	for _, to := range this.caves.To(path.From()) {
 		if to == End {
 			paths++
-		} else if caves.IsLarge(to) || !path.Seen(to) {
-			next := path.Extend(to)
-			paths += walk2(next, caves)
-		} else if !path.Seen(Double) {
-			next := path.Extend(to)
-			next.SeenDouble()
-			paths += walk2(next, caves)
+		} else if this.caves.IsLarge(to) || path.visits[to] == 0 ||
+			(path.visits[to] == 1 && path.doubles < this.k) {
+			path.Extend(to)
+			paths += this.countPaths()
+			path.Retract()
 		}
 	}
+
+	this.memo[stored] = paths
+	return paths
+}
+
+// countSimple counts the simple paths from the current cave, which is small
+// or the start, to the end.
+func (this *Counter) countSimple() int {
+	path := &this.path
+	from := path.From()
+
+	key := this.findBlock(from).AppendBytes(this.key[:0])
+	key = Bitset{uint64(from)}.AppendBytes(key)
+	if paths, found := this.simple[string(key)]; found {
+		this.hits++
+		return paths
+	}
+	stored := string(key)
+	block := append(Bitset(nil), this.block...)
+
+	paths := this.links.weight[from][End]
+	for i, word := range this.links.next[from] {
+		for word &= block[i]; word != 0; word &= word - 1 {
+			to := Cave(i*64 + bits.TrailingZeros64(word))
+			if to == End {
+				continue
+			}
+			path.Extend(to)
+			paths += this.links.weight[from][to] * this.countSimple()
+			path.Retract()
+		}
+	}
+
+	this.simple[stored] = paths
 	return paths
 }
 
+// findBlock finds the block holding from and the end in the links between
+// small caves which have not been visited, using Tarjan's algorithm. The
+// search starts at the end, as if it had come from the current cave.
+func (this *Counter) findBlock(from Cave) Bitset {
+	for i := range this.seen {
+		this.seen[i] = 0
+		this.block[i] = 0
+	}
+	this.time = 0
+	this.stack = this.stack[:0]
+
+	this.explore(End, from)
+
+	for _, cave := range this.stack {
+		this.block.Set(cave)
+	}
+	this.block.Set(from)
+	return this.block
+}
+
+// explore returns the lowest discovery time which the caves searched from
+// cave link back to. Blocks found on the way which can't hold from are
+// dropped from the stack.
+func (this *Counter) explore(cave, from Cave) int {
+	this.time++
+	this.disc[cave] = this.time
+	this.seen.Set(cave)
+	this.stack = append(this.stack, cave)
+
+	low := this.disc[cave]
+	if cave != End && this.links.next[from].Has(cave) {
+		low = 0 // linked back to from, which comes before everything
+	}
+	for i, word := range this.links.joined[cave] {
+		for word &^= this.path.once[i]; word != 0; word &= word - 1 {
+			next := Cave(i*64 + bits.TrailingZeros64(word))
+			if this.seen.Has(next) {
+				low = min(low, this.disc[next])
+				continue
+			}
+
+			nextLow := this.explore(next, from)
+			if nextLow < this.disc[cave] {
+				low = min(low, nextLow)
+				continue
+			}
+			for {
+				top := this.stack[len(this.stack)-1]
+				this.stack = this.stack[:len(this.stack)-1]
+				if top == next {
+					break
+				}
+			}
+		}
+	}
+	return low
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Links join small caves (and the start and end) directly, standing in for
+// the passages between them and any trips through a single large cave. A
+// large cave can be visited any number of times, but only between two small
+// caves, as large caves are never joined to each other.
+type Links struct {
+	weight [][]int  // how many ways to go from one small cave to another
+	next   []Bitset // the caves with a positive weight from each cave
+	joined []Bitset // next in both directions, leaving out the start
+}
+
+func MakeLinks(caves *Caves) Links {
+	n := len(caves.to)
+	links := Links{
+		weight: make([][]int, n),
+		next:   make([]Bitset, n),
+		joined: make([]Bitset, n),
+	}
+	for i := range links.weight {
+		links.weight[i] = make([]int, n)
+		links.next[i] = MakeBitset(n)
+		links.joined[i] = MakeBitset(n)
+	}
+
+	add := func(from, to Cave) {
+		links.weight[from][to]++
+		links.next[from].Set(to)
+		if from != Start {
+			links.joined[from].Set(to)
+			links.joined[to].Set(from)
+		}
+	}
+	for from := range caves.to {
+		if caves.IsLarge(Cave(from)) {
+			continue
+		}
+		for _, to := range caves.To(Cave(from)) {
+			if !caves.IsLarge(to) {
+				add(Cave(from), to)
+				continue
+			}
+			for _, beyond := range caves.To(to) {
+				if beyond != Cave(from) {
+					add(Cave(from), beyond)
+				}
+			}
+		}
+	}
+	return links
+}
+
+// RandomCaves makes a cave system with the given number of small and large
+// caves, as lines of input. Large caves are never joined to each other, as
+// that would allow endless paths. Negative large or edges pick the defaults.
+//
+// Going through a large cave joins all its neighbours to each other, so even
+// a few more passages give far more paths. With the defaults, memoisation
+// wins from about 40 small caves, where it is five times faster for part 1;
+// at 50 it is thirty times faster. Part 1 stays under a few seconds up to
+// about 60 small caves and part 2 up to about 40. With twice as many
+// passages as caves, memoisation is forty times faster at 25 small caves,
+// but at 40 there are too many paths for either to finish.
+func RandomCaves(small, large, edges int, rng *rand.Rand) []string {
+	if large < 0 {
+		large = small / 4
+	}
+	names := []string{"start", "end"}
+	for i := 0; i < small; i++ {
+		names = append(names, fmt.Sprintf("s%d", i))
+	}
+	for i := 0; i < large; i++ {
+		names = append(names, fmt.Sprintf("L%d", i))
+	}
+	if edges < 0 {
+		edges = small + small/2
+	}
+
+	isLarge := func(i int) bool { return i >= 2+small }
+	maxEdges := 0
+	for a := range names {
+		for b := a + 1; b < len(names); b++ {
+			if !(isLarge(a) && isLarge(b)) && !(a == Start && b == End) {
+				maxEdges++
+			}
+		}
+	}
+	if edges > maxEdges {
+		edges = maxEdges
+	}
+
+	type Edge struct{ a, b int }
+	seen := make(map[Edge]bool)
+	lines := make([]string, 0, edges)
+	for len(lines) < edges {
+		a, b := rng.Intn(len(names)), rng.Intn(len(names))
+		if a > b {
+			a, b = b, a
+		}
+		if a == b || (isLarge(a) && isLarge(b)) || (a == Start && b == End) || seen[Edge{a, b}] {
+			continue
+		}
+		seen[Edge{a, b}] = true
+		lines = append(lines, names[a]+"-"+names[b])
+	}
+	return lines
+}
+
 func parseCaves(filename string) Caves {
 	cavemap := MakeCaveMap()
 	lines := aoc.GetInputLines(filename)
-	isLarge := Cave(0)
 	for _, line := range lines {
 		for _, end := range strings.Split(line, "-") {
-			value := cavemap.AssignValue(end)
-			if strings.ToUpper(end) == end { // ffs...
-				isLarge |= 1 << value
-			}
+			cavemap.AssignValue(end)
 		}
 	}
 
-	caves := MakeCaves(int(cavemap.next), isLarge)
+	caves := MakeCaves(cavemap.names)
 	for _, line := range lines {
 		ends := strings.Split(line, "-")
 		a := cavemap.Lookup(ends[0])
@@ -131,7 +511,15 @@ func (this *Caves) To(from Cave) []Cave {
 }
 
 func (this *Caves) IsLarge(cave Cave) bool {
-	return (this.isLarge & (1 << cave)) != 0
+	return this.isLarge[cave]
+}
+
+func (this *Caves) RouteString(route []Cave) string {
+	names := make([]string, len(route))
+	for i, cave := range route {
+		names[i] = this.names[cave]
+	}
+	return strings.Join(names, ",")
 }
 
 func (this *CaveMap) AssignValue(cavename string) Cave {
@@ -139,9 +527,9 @@ func (this *CaveMap) AssignValue(cavename string) Cave {
 	if found {
 		return value
 	}
-	value = this.next
+	value = Cave(len(this.names))
 	this.mapping[cavename] = value
-	this.next++
+	this.names = append(this.names, cavename)
 	return value
 }
 
@@ -152,14 +540,46 @@ func (this *CaveMap) Lookup(cave string) Cave {
 	return -1
 }
 
-func (this *Path) Extend(cave Cave) Path {
-	return Path{seen: this.seen | 1<<cave, from: cave}
+func (this *Path) From() Cave {
+	return this.route[len(this.route)-1]
 }
 
-func (this *Path) SeenDouble() {
-	this.seen |= 1 << Double
+func (this *Path) Extend(cave Cave) {
+	this.route = append(this.route, cave)
+	this.visits[cave]++
+	if !this.caves.IsLarge(cave) {
+		switch this.visits[cave] {
+		case 1:
+			this.once.Set(cave)
+		case 2:
+			this.twice.Set(cave)
+			this.doubles++
+		}
+	}
 }
 
-func (this *Path) Seen(cave Cave) bool {
-	return (this.seen & (1 << cave)) != 0
+// Key identifies the state of the path for memoisation: the current cave
+// and the small caves visited so far. It is only valid until the path next
+// changes; converting it to a string for a map lookup does not copy it.
+func (this *Path) Key() []byte {
+	key := this.once.AppendBytes(this.key[:0])
+	key = this.twice.AppendBytes(key)
+
+	from, doubles := uint64(this.From()), uint64(this.doubles)
+	return Bitset{from | doubles<<32}.AppendBytes(key)
+}
+
+func (this *Path) Retract() {
+	cave := this.From()
+	if !this.caves.IsLarge(cave) {
+		switch this.visits[cave] {
+		case 1:
+			this.once.Clear(cave)
+		case 2:
+			this.twice.Clear(cave)
+			this.doubles--
+		}
+	}
+	this.visits[cave]--
+	this.route = this.route[:len(this.route)-1]
 }
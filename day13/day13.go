@@ -2,7 +2,9 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
+	"log"
 	"strings"
 )
 
@@ -15,51 +17,205 @@ type Fold struct {
 	pos int
 }
 
+// A Sheet is the transparent paper, size.p[0] wide and size.p[1] high.
+type Sheet struct {
+	dots map[Point]bool
+	size Point
+}
+
+var render = flag.Bool("render", false, "draw the folded paper as well as reading it")
+var inspect = flag.Bool("inspect", false, "show the paper after every fold")
+
 func main() {
 	points, folds := getInput(aoc.GetFilename())
 
-	fmt.Println(part1(points, folds))
-	part2(points, folds)
+	sheets, err := FoldAll(MakeSheet(points), folds)
+	if *inspect {
+		for i, sheet := range sheets {
+			if i == 0 {
+				fmt.Print("before folding")
+			} else {
+				fmt.Printf("after %v", folds[i-1])
+			}
+			min, max := sheet.Bounds()
+			fmt.Printf(": %d dots within %v-%v on %dx%d paper\n%s\n",
+				sheet.DotCount(), min, max, sheet.size.p[0], sheet.size.p[1], sheet.Render('#', '.'))
+		}
+	}
+	aoc.CheckErr(err)
+	if len(folds) == 0 {
+		log.Fatal("No folds in the input")
+	}
+
+	fmt.Println(part1(sheets))
+	fmt.Println(part2(sheets))
+}
+
+func part1(sheets []Sheet) int {
+	return sheets[1].DotCount()
 }
 
-func part1(points []Point, folds []Fold) int {
-	unique := make(map[Point]bool)
+func part2(sheets []Sheet) string {
+	sheet := sheets[len(sheets)-1]
 
-	for _, in := range points {
-		out := folds[0].Apply(in)
-		unique[out] = true
+	if *render {
+		fmt.Print(sheet.Render('#', ' '))
 	}
-	return len(unique)
-}
 
-func part2(points []Point, folds []Fold) {
-	dot := make(map[Point]bool)
-	max := makePoint(0, 0)
+	_, max := sheet.Bounds()
+	return ReadLetters(sheet.dots, max)
+}
 
+// MakeSheet uses the smallest paper which holds every dot.
+func MakeSheet(points []Point) Sheet {
+	sheet := Sheet{dots: make(map[Point]bool), size: makePoint(0, 0)}
 	for _, p := range points {
-		for _, f := range folds {
-			p = f.Apply(p)
+		sheet.dots[p] = true
+		for i := 0; i < 2; i++ {
+			if p.p[i] >= sheet.size.p[i] {
+				sheet.size.p[i] = p.p[i] + 1
+			}
+		}
+	}
+	return sheet
+}
+
+// FoldAll returns the sheet before any folds and after each fold. If a fold
+// is invalid, the sheets up to that fold are returned with the error.
+func FoldAll(sheet Sheet, folds []Fold) ([]Sheet, error) {
+	sheets := []Sheet{sheet}
+	for _, fold := range folds {
+		next, err := sheet.Fold(fold)
+		if err != nil {
+			return sheets, err
 		}
+		sheets = append(sheets, next)
+		sheet = next
+	}
+	return sheets, nil
+}
+
+// Fold checks that no dots are on the fold line, and that the part being
+// folded over is no bigger than the part it lands on.
+func (this *Sheet) Fold(fold Fold) (Sheet, error) {
+	size := this.size.p[fold.dir]
+	if fold.pos >= size {
+		return Sheet{}, fmt.Errorf("%v is outside the paper", fold)
+	}
+	if size-1-fold.pos > fold.pos {
+		return Sheet{}, fmt.Errorf("%v folds %d lines onto %d", fold, size-1-fold.pos, fold.pos)
+	}
 
-		dot[p] = true
+	next := Sheet{dots: make(map[Point]bool), size: this.size}
+	next.size.p[fold.dir] = fold.pos
+	for p := range this.dots {
+		if p.p[fold.dir] == fold.pos {
+			return Sheet{}, fmt.Errorf("%v goes through the dot at %v", fold, p)
+		}
+		next.dots[fold.Apply(p)] = true
+	}
+	return next, nil
+}
+
+func (this *Sheet) DotCount() int {
+	return len(this.dots)
+}
+
+// Bounds gives the smallest box holding every dot.
+func (this *Sheet) Bounds() (Point, Point) {
+	first := true
+	var min, max Point
+	for p := range this.dots {
 		for i := 0; i < 2; i++ {
-			if p.p[i] > max.p[i] {
+			if first || p.p[i] < min.p[i] {
+				min.p[i] = p.p[i]
+			}
+			if first || p.p[i] > max.p[i] {
 				max.p[i] = p.p[i]
 			}
 		}
+		first = false
 	}
+	return min, max
+}
 
-	for y := 0; y <= max.p[1]; y++ {
-		for x := 0; x <= max.p[0]; x++ {
-			p := makePoint(x, y)
-			if dot[p] {
-				fmt.Print("#")
+func (this *Sheet) Render(dot, blank byte) string {
+	var b strings.Builder
+	for y := 0; y < this.size.p[1]; y++ {
+		for x := 0; x < this.size.p[0]; x++ {
+			if this.dots[makePoint(x, y)] {
+				b.WriteByte(dot)
 			} else {
-				fmt.Print(" ")
+				b.WriteByte(blank)
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (this Point) String() string {
+	return fmt.Sprintf("(%d,%d)", this.p[0], this.p[1])
+}
+
+func (this Fold) String() string {
+	return fmt.Sprintf("fold along %c=%d", "xy"[this.dir], this.pos)
+}
+
+//------------------------------------------------------------------------------
+
+const (
+	GlyphWidth  = 4
+	GlyphHeight = 6
+	GlyphStride = GlyphWidth + 1 // one blank column between letters
+)
+
+// The letters of the puzzle font, each row drawn with '#' and '.'.
+var Glyphs = map[string]rune{
+	".##.#..##..######..##..#": 'A',
+	"###.#..####.#..##..####.": 'B',
+	".##.#..##...#...#..#.##.": 'C',
+	"#####...###.#...#...####": 'E',
+	"#####...###.#...#...#...": 'F',
+	".##.#..##...#.###..#.###": 'G',
+	"#..##..######..##..##..#": 'H',
+	".###..#...#...#...#..###": 'I',
+	"..##...#...#...##..#.##.": 'J',
+	"#..##.#.##..#.#.#.#.#..#": 'K',
+	"#...#...#...#...#...####": 'L',
+	".##.#..##..##..##..#.##.": 'O',
+	"###.#..##..####.#...#...": 'P',
+	"###.#..##..####.#.#.#..#": 'R',
+	".####...#....##....####.": 'S',
+	"#..##..##..##..##..#.##.": 'U',
+	"####...#..#..#..#...####": 'Z',
+}
+
+// ReadLetters reads the dots as a row of letters in the puzzle font, which
+// starts at the origin. Unrecognised letters are read as '?'.
+func ReadLetters(dot map[Point]bool, max Point) string {
+	count := (max.p[0] + GlyphStride) / GlyphStride
+
+	var text strings.Builder
+	for i := 0; i < count; i++ {
+		var glyph strings.Builder
+		for y := 0; y < GlyphHeight; y++ {
+			for x := 0; x < GlyphWidth; x++ {
+				if dot[makePoint(i*GlyphStride+x, y)] {
+					glyph.WriteByte('#')
+				} else {
+					glyph.WriteByte('.')
+				}
 			}
 		}
-		fmt.Print("\n")
+
+		if letter, found := Glyphs[glyph.String()]; found && max.p[1] < GlyphHeight {
+			text.WriteRune(letter)
+		} else {
+			text.WriteRune('?')
+		}
 	}
+	return text.String()
 }
 
 func makePoint(x, y int) Point {
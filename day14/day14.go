@@ -2,84 +2,298 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
-	"math"
+	"log"
+	"math/big"
+	"sort"
 	"strings"
 )
 
 type Rules map[string]byte
-type Hist map[byte]int
-type Signature struct {
-	lhs, rhs byte
-	depth    int
+
+// A Polymer tracks how many of each pair of adjacent elements there are,
+// rather than the polymer itself. Pair a*len(elements)+b is elements[a]
+// followed by elements[b], and each step it becomes the pairs in next.
+type Polymer struct {
+	template string
+	elements []byte
+	index    map[byte]int
+	next     [][]int
 }
-type Memo map[Signature]Hist
 
-var memo Memo = make(Memo)
+// The polymer roughly doubles in length each step, so exact counts after n
+// steps have about n bits. Past this many steps they take too long to find.
+const MaxExactSteps = 20000
+
+var steps = flag.Uint64("steps", 0, "print each element's count after this many steps")
+var element = flag.String("element", "", "only print the count of this element with -steps")
+var mod = flag.String("mod", "", "with -steps, give counts modulo this number")
+var check = flag.Bool("check", false, "check the step-by-step counts against matrix exponentiation")
 
 func main() {
-	start, rules := getInput(aoc.GetFilename())
+	template, rules := getInput(aoc.GetFilename())
+	polymer := MakePolymer(template, rules)
+
+	fmt.Println(part1(&polymer))
+	fmt.Println(part2(&polymer))
 
-	fmt.Println(part1(start, rules))
-	fmt.Println(part2(start, rules))
+	if *check {
+		for _, n := range []int{0, 1, 10, 40} {
+			counts := polymer.Counts(n)
+			bigCounts := polymer.BigCounts(uint64(n), nil)
+			for e, count := range counts {
+				if bigCounts[e].Cmp(big.NewInt(int64(count))) != 0 {
+					log.Fatalf("Step %d: %c is %d step by step, but %v by matrix", n, e, count, bigCounts[e])
+				}
+			}
+		}
+		fmt.Println("step-by-step and matrix counts agree")
+	}
+
+	if *steps > 0 {
+		var modulus *big.Int
+		if *mod != "" {
+			var ok bool
+			if modulus, ok = new(big.Int).SetString(*mod, 10); !ok || modulus.Sign() <= 0 {
+				log.Fatalf("Bad modulus %q", *mod)
+			}
+		} else if *steps > MaxExactSteps {
+			log.Fatalf("Exact counts after %d steps would have about %d bits; use -mod for more than %d steps",
+				*steps, *steps, MaxExactSteps)
+		}
+		counts := polymer.BigCounts(*steps, modulus)
+		for _, e := range polymer.elements {
+			if *element == "" || *element == string(e) {
+				fmt.Printf("%c: %v\n", e, counts[e])
+			}
+		}
+	}
 }
 
-func part1(start string, rules Rules) int {
-	return findScore(start, rules, 10)
+func part1(polymer *Polymer) int {
+	return Score(polymer.Counts(10))
 }
 
-func part2(start string, rules Rules) int {
-	return findScore(start, rules, 40)
+func part2(polymer *Polymer) int {
+	return Score(polymer.Counts(40))
 }
 
-func findScore(start string, rules Rules, depth int) int {
-	hist := make(Hist)
-	for _, letter := range start {
-		hist[byte(letter)]++
+// Score is the count of the most common element minus the least common.
+func Score(counts map[byte]int) int {
+	first := true
+	least, most := 0, 0
+	for _, count := range counts {
+		if first || count > most {
+			most = count
+		}
+		if first || count < least {
+			least = count
+		}
+		first = false
 	}
+	return most - least
+}
+
+//------------------------------------------------------------------------------
+
+func MakePolymer(template string, rules Rules) Polymer {
+	polymer := Polymer{template: template, index: make(map[byte]int)}
 
-	n := len(start) - 1
-	for i := 0; i < n; i++ {
-		addHist(hist, recurse(start[i], start[i+1], rules, depth))
+	addElement := func(e byte) {
+		if _, found := polymer.index[e]; !found {
+			polymer.index[e] = 0
+			polymer.elements = append(polymer.elements, e)
+		}
+	}
+	for i := range template {
+		addElement(template[i])
+	}
+	for pair, mid := range rules {
+		addElement(pair[0])
+		addElement(pair[1])
+		addElement(mid)
+	}
+	sort.Slice(polymer.elements, func(i, j int) bool {
+		return polymer.elements[i] < polymer.elements[j]
+	})
+	for i, e := range polymer.elements {
+		polymer.index[e] = i
 	}
 
-	least, most := math.MaxInt, 0
-	for _, count := range hist {
-		if count > most {
-			most = count
-		} else if count > 0 && count < least {
-			least = count
+	// A pair with no rule is left alone.
+	n := len(polymer.elements)
+	polymer.next = make([][]int, n*n)
+	for a, lhs := range polymer.elements {
+		for b, rhs := range polymer.elements {
+			pair := a*n + b
+			if mid, found := rules[string([]byte{lhs, rhs})]; found {
+				m := polymer.index[mid]
+				polymer.next[pair] = []int{a*n + m, m*n + b}
+			} else {
+				polymer.next[pair] = []int{pair}
+			}
 		}
 	}
 
-	return most - least
+	return polymer
+}
+
+// PairCounts gives how many of each pair there are after the given number
+// of steps.
+func (this *Polymer) PairCounts(steps int) []int {
+	counts := make([]int, len(this.next))
+	for i := 0; i+1 < len(this.template); i++ {
+		counts[this.pair(this.template[i], this.template[i+1])]++
+	}
+
+	for ; steps > 0; steps-- {
+		next := make([]int, len(counts))
+		for pair, count := range counts {
+			for _, to := range this.next[pair] {
+				next[to] += count
+			}
+		}
+		counts = next
+	}
+	return counts
+}
+
+// Counts gives how many of each element there are after the given number of
+// steps. Each element starts one pair, apart from the last, which never
+// changes.
+func (this *Polymer) Counts(steps int) map[byte]int {
+	n := len(this.elements)
+	counts := make(map[byte]int)
+	for _, e := range this.elements {
+		counts[e] = 0
+	}
+	for pair, count := range this.PairCounts(steps) {
+		counts[this.elements[pair/n]] += count
+	}
+	if len(this.template) > 0 {
+		counts[this.template[len(this.template)-1]]++
+	}
+	return counts
 }
 
-func recurse(lhs, rhs byte, rules Rules, depth int) Hist {
-	signature := Signature{lhs: lhs, rhs: rhs, depth: depth}
-	if answer, found := memo[signature]; found {
-		return answer
+func (this *Polymer) Count(element byte, steps int) int {
+	return this.Counts(steps)[element]
+}
+
+// BigCounts is Counts for any number of steps, raising the step matrix to
+// that power by repeated squaring. The polymer roughly doubles in length
+// each step, so past a few million steps the exact counts are too big to
+// hold; a non-nil modulus keeps them in range.
+func (this *Polymer) BigCounts(steps uint64, modulus *big.Int) map[byte]*big.Int {
+	size := len(this.next)
+
+	reduce := func(x *big.Int) {
+		if modulus != nil {
+			x.Mod(x, modulus)
+		}
 	}
 
-	hist := make(Hist)
-	mid := rules[string([]byte{lhs, rhs})]
-	hist[mid] = 1
+	vector := make([]*big.Int, size)
+	for i := range vector {
+		vector[i] = new(big.Int)
+	}
+	for i := 0; i+1 < len(this.template); i++ {
+		pair := this.pair(this.template[i], this.template[i+1])
+		vector[pair].Add(vector[pair], big.NewInt(1))
+	}
 
-	if depth--; depth > 0 {
-		addHist(hist, recurse(lhs, mid, rules, depth))
-		addHist(hist, recurse(mid, rhs, rules, depth))
+	// step[to][from] is how many of pair to one pair from becomes.
+	step := makeMatrix(size)
+	for from, tos := range this.next {
+		for _, to := range tos {
+			step[to][from].Add(step[to][from], big.NewInt(1))
+		}
 	}
 
-	memo[signature] = hist
-	return hist
+	for ; steps > 0; steps >>= 1 {
+		if steps&1 != 0 {
+			vector = step.Apply(vector, reduce)
+		}
+		if steps > 1 {
+			step = step.Multiply(step, reduce)
+		}
+	}
+
+	n := len(this.elements)
+	counts := make(map[byte]*big.Int)
+	for _, e := range this.elements {
+		counts[e] = new(big.Int)
+	}
+	for pair, count := range vector {
+		e := this.elements[pair/n]
+		counts[e].Add(counts[e], count)
+	}
+	if len(this.template) > 0 {
+		last := this.template[len(this.template)-1]
+		counts[last].Add(counts[last], big.NewInt(1))
+	}
+	for _, count := range counts {
+		reduce(count)
+	}
+	return counts
 }
 
-func addHist(to, from Hist) {
-	for key, value := range from {
-		to[key] += value
+func (this *Polymer) pair(lhs, rhs byte) int {
+	return this.index[lhs]*len(this.elements) + this.index[rhs]
+}
+
+//------------------------------------------------------------------------------
+
+type Matrix [][]*big.Int
+
+func makeMatrix(size int) Matrix {
+	matrix := make(Matrix, size)
+	for i := range matrix {
+		matrix[i] = make([]*big.Int, size)
+		for j := range matrix[i] {
+			matrix[i][j] = new(big.Int)
+		}
+	}
+	return matrix
+}
+
+func (this Matrix) Multiply(other Matrix, reduce func(*big.Int)) Matrix {
+	product := makeMatrix(len(this))
+	term := new(big.Int)
+	for i, row := range this {
+		for k, a := range row {
+			if a.Sign() == 0 {
+				continue
+			}
+			for j, b := range other[k] {
+				if b.Sign() != 0 {
+					product[i][j].Add(product[i][j], term.Mul(a, b))
+				}
+			}
+		}
+		for _, x := range product[i] {
+			reduce(x)
+		}
+	}
+	return product
+}
+
+func (this Matrix) Apply(vector []*big.Int, reduce func(*big.Int)) []*big.Int {
+	result := make([]*big.Int, len(this))
+	term := new(big.Int)
+	for i, row := range this {
+		result[i] = new(big.Int)
+		for j, a := range row {
+			result[i].Add(result[i], term.Mul(a, vector[j]))
+		}
+		reduce(result[i])
 	}
+	return result
 }
 
+//------------------------------------------------------------------------------
+
 func getInput(filename string) (string, Rules) {
 	lines := aoc.GetInputLines(filename)
 	start := lines[0]
@@ -87,9 +301,10 @@ func getInput(filename string) (string, Rules) {
 
 	for _, line := range lines[2:] {
 		parts := strings.Split(line, " -> ")
-		from := parts[0]
-		to := parts[1][0]
-		rules[from] = to
+		if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 1 {
+			log.Fatal("Expecting XY -> Z, got ", line)
+		}
+		rules[parts[0]] = parts[1][0]
 	}
 
 	return start, rules
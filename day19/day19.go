@@ -2,14 +2,31 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
 	"regexp"
 	"strings"
 )
 
+var bench = flag.Bool("bench", false, "benchmark brute force against fingerprint matching")
+var printTransforms = flag.Bool("transforms", false, "print the transform for each scanner")
+
 func main() {
 	scanners := ParseInput(aoc.GetFilename())
-	transforms := CreateScannerTransforms(scanners)
+
+	if *bench {
+		aoc.Benchmark("brute-force", func() { CreateScannerTransforms(scanners, false) })
+		aoc.Benchmark("fingerprint", func() { CreateScannerTransforms(scanners, true) })
+		return
+	}
+
+	transforms := CreateScannerTransforms(scanners, true)
+
+	if *printTransforms {
+		for i, transform := range transforms {
+			fmt.Printf("scanner %d: %s\n", i, transform)
+		}
+	}
 
 	fmt.Println(part1(scanners, transforms))
 	fmt.Println(part2(transforms))
@@ -25,7 +42,7 @@ func part1(scanners []Scanner, transforms []Transform) int {
 		transform := transforms[i]
 
 		for _, from := range scanner {
-			to := transform(from)
+			to := transform.Apply(from)
 			unique[to] = true
 		}
 	}
@@ -38,7 +55,7 @@ func part2(transforms []Transform) int {
 	origin := MakePoint(0, 0, 0)
 	pos := make([]Point, len(transforms))
 	for i, transform := range transforms {
-		pos[i] = transform(origin)
+		pos[i] = transform.Apply(origin)
 	}
 
 	best := 0
@@ -80,17 +97,24 @@ func ParseInput(filename string) []Scanner {
 	return scanner
 }
 
+// Two scanners must see at least this many beacons in common to be matched.
+const MinOverlap = 12
+
+// Overlapping scanners share the pairwise distances between their common
+// beacons, so they must have at least this many distances in common.
+const MinSharedDistances = MinOverlap * (MinOverlap - 1) / 2
+
 func CompareScanners(s0, s1 Scanner) (Transform, bool) {
 	type Hist map[Point]int
 
 	for _, rot := range rotations {
 		hist := make(Hist)
 		for _, p1 := range s1 {
-			rp1 := rot(p1)
+			rp1 := rot.Apply(p1)
 			for _, p0 := range s0 {
 				p := p0.Sub(rp1)
 				if count, found := hist[p]; found {
-					if count == 11 {
+					if count == MinOverlap-1 {
 						return rot.MakeTransform(p), true
 					}
 					hist[p] = count + 1
@@ -100,10 +124,21 @@ func CompareScanners(s0, s1 Scanner) (Transform, bool) {
 			}
 		}
 	}
-	return nil, false
+	return Transform{}, false
 }
 
-func CreateScannerTransforms(scanners []Scanner) []Transform {
+func CreateScannerTransforms(scanners []Scanner, useFingerprints bool) []Transform {
+	mayOverlap := func(from, to int) bool { return true }
+	if useFingerprints {
+		fingerprints := make([]Fingerprint, len(scanners))
+		for i, scanner := range scanners {
+			fingerprints[i] = MakeFingerprint(scanner)
+		}
+		mayOverlap = func(from, to int) bool {
+			return fingerprints[from].Shared(fingerprints[to]) >= MinSharedDistances
+		}
+	}
+
 	scannerTransform := make([]Transform, len(scanners))
 	scannerTransform[0] = Identity
 
@@ -122,10 +157,12 @@ func CreateScannerTransforms(scanners []Scanner) []Transform {
 		stillUnmapped := make([]int, 0, len(unmapped))
 
 		for _, to := range unmapped {
-			if transform, found := CompareScanners(scanners[from], scanners[to]); found {
+			if !mayOverlap(from, to) {
+				stillUnmapped = append(stillUnmapped, to)
+			} else if transform, found := CompareScanners(scanners[from], scanners[to]); found {
 				candidates = append(candidates, to)
 				//fmt.Printf("Scanner %d -> %d\n", from, to)
-				scannerTransform[to] = scannerTransform[from].Extend(transform)
+				scannerTransform[to] = scannerTransform[from].Compose(transform)
 			} else {
 				stillUnmapped = append(stillUnmapped, to)
 			}
@@ -139,6 +176,33 @@ func CreateScannerTransforms(scanners []Scanner) []Transform {
 
 //------------------------------------------------------------------------------
 
+// A Fingerprint is the multiset of squared distances between each pair of
+// beacons seen by a scanner. It is the same under any rotation or translation.
+type Fingerprint map[int]int
+
+func MakeFingerprint(s Scanner) Fingerprint {
+	fp := make(Fingerprint)
+	for i, p0 := range s {
+		for _, p1 := range s[i+1:] {
+			fp[p0.SquaredDistance(p1)]++
+		}
+	}
+	return fp
+}
+
+func (f Fingerprint) Shared(g Fingerprint) int {
+	if len(g) < len(f) {
+		f, g = g, f
+	}
+	shared := 0
+	for dist, count := range f {
+		shared += min(count, g[dist])
+	}
+	return shared
+}
+
+//------------------------------------------------------------------------------
+
 type Point struct {
 	x, y, z int
 }
@@ -153,6 +217,10 @@ func ParsePoint(line string) Point {
 	return MakePoint(numbers[0], numbers[1], numbers[2])
 }
 
+func (p Point) Coords() [3]int {
+	return [3]int{p.x, p.y, p.z}
+}
+
 func (p Point) Add(q Point) Point {
 	return MakePoint(p.x+q.x, p.y+q.y, p.z+q.z)
 }
@@ -165,6 +233,18 @@ func (p Point) ManhattanDistance(q Point) int {
 	return abs(p.x-q.x) + abs(p.y-q.y) + abs(p.z-q.z)
 }
 
+func (p Point) SquaredDistance(q Point) int {
+	d := p.Sub(q)
+	return d.x*d.x + d.y*d.y + d.z*d.z
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 func abs(x int) int {
 	if x < 0 {
 		return -x
@@ -172,53 +252,132 @@ func abs(x int) int {
 	return x
 }
 
-type Rotation func(Point) Point
-type Transform func(Point) Point
+// A Rotation is a 3x3 integer matrix, applied to column vectors.
+type Rotation [3][3]int
 
-func Identity(p Point) Point {
-	return p
+var IdentityRotation = Rotation{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+func (r Rotation) Apply(p Point) Point {
+	v := p.Coords()
+	var out [3]int
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			out[i] += r[i][j] * v[j]
+		}
+	}
+	return MakePoint(out[0], out[1], out[2])
+}
+
+func (r Rotation) Mul(s Rotation) Rotation {
+	var out Rotation
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				out[i][j] += r[i][k] * s[k][j]
+			}
+		}
+	}
+	return out
+}
+
+// The inverse of a rotation matrix is its transpose.
+func (r Rotation) Inverse() Rotation {
+	var out Rotation
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			out[i][j] = r[j][i]
+		}
+	}
+	return out
+}
+
+func (r Rotation) Determinant() int {
+	return r[0][0]*(r[1][1]*r[2][2]-r[1][2]*r[2][1]) -
+		r[0][1]*(r[1][0]*r[2][2]-r[1][2]*r[2][0]) +
+		r[0][2]*(r[1][0]*r[2][1]-r[1][1]*r[2][0])
+}
+
+func (r Rotation) MakeTransform(offset Point) Transform {
+	return Transform{rot: r, offset: offset}
 }
 
-func (rot Rotation) MakeTransform(offset Point) Transform {
-	return func(p Point) Point {
-		return rot(p).Add(offset)
+// A Transform rotates a point and then translates it by offset.
+type Transform struct {
+	rot    Rotation
+	offset Point
+}
+
+var Identity = Transform{rot: IdentityRotation}
+
+func (t Transform) Apply(p Point) Point {
+	return t.rot.Apply(p).Add(t.offset)
+}
+
+// t1.Compose(t2) is the transform which applies t2 and then t1.
+func (t1 Transform) Compose(t2 Transform) Transform {
+	return Transform{
+		rot:    t1.rot.Mul(t2.rot),
+		offset: t1.Apply(t2.offset),
 	}
 }
 
-func (t1 Transform) Extend(t2 Transform) Transform {
-	return func(p Point) Point {
-		return t1(t2(p))
+func (t Transform) Inverse() Transform {
+	inv := t.rot.Inverse()
+	return Transform{
+		rot:    inv,
+		offset: inv.Apply(MakePoint(0, 0, 0).Sub(t.offset)),
 	}
 }
 
-var rotations [24]Rotation = [...]Rotation{
-	func(p Point) Point { return MakePoint(+p.x, +p.y, +p.z) },
-	func(p Point) Point { return MakePoint(+p.y, -p.x, +p.z) },
-	func(p Point) Point { return MakePoint(-p.x, -p.y, +p.z) },
-	func(p Point) Point { return MakePoint(-p.y, +p.x, +p.z) },
+func (t Transform) String() string {
+	r := t.rot
+	return fmt.Sprintf("%d,%d,%d/%d,%d,%d/%d,%d,%d@%d,%d,%d",
+		r[0][0], r[0][1], r[0][2],
+		r[1][0], r[1][1], r[1][2],
+		r[2][0], r[2][1], r[2][2],
+		t.offset.x, t.offset.y, t.offset.z)
+}
+
+var TransformRegex = regexp.MustCompile(
+	"^(-?\\d+),(-?\\d+),(-?\\d+)/(-?\\d+),(-?\\d+),(-?\\d+)/(-?\\d+),(-?\\d+),(-?\\d+)@(-?\\d+),(-?\\d+),(-?\\d+)$")
 
-	func(p Point) Point { return MakePoint(-p.x, +p.y, -p.z) },
-	func(p Point) Point { return MakePoint(-p.y, -p.x, -p.z) },
-	func(p Point) Point { return MakePoint(+p.x, -p.y, -p.z) },
-	func(p Point) Point { return MakePoint(+p.y, +p.x, -p.z) },
+// ParseTransform is the inverse of Transform.String.
+func ParseTransform(text string) (Transform, error) {
+	matches := TransformRegex.FindStringSubmatch(text)
+	if len(matches) != 13 {
+		return Transform{}, fmt.Errorf("bad transform %q", text)
+	}
+	n := aoc.ParseInts(matches[1:])
 
-	func(p Point) Point { return MakePoint(-p.y, -p.z, +p.x) },
-	func(p Point) Point { return MakePoint(+p.z, -p.y, +p.x) },
-	func(p Point) Point { return MakePoint(+p.y, +p.z, +p.x) },
-	func(p Point) Point { return MakePoint(-p.z, +p.y, +p.x) },
+	var t Transform
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			t.rot[i][j] = n[i*3+j]
+		}
+	}
+	t.offset = MakePoint(n[9], n[10], n[11])
+	return t, nil
+}
 
-	func(p Point) Point { return MakePoint(-p.y, +p.z, -p.x) },
-	func(p Point) Point { return MakePoint(-p.z, -p.y, -p.x) },
-	func(p Point) Point { return MakePoint(+p.y, -p.z, -p.x) },
-	func(p Point) Point { return MakePoint(+p.z, +p.y, -p.x) },
+var rotations = generateRotations()
 
-	func(p Point) Point { return MakePoint(-p.x, +p.z, +p.y) },
-	func(p Point) Point { return MakePoint(-p.z, -p.x, +p.y) },
-	func(p Point) Point { return MakePoint(+p.x, -p.z, +p.y) },
-	func(p Point) Point { return MakePoint(+p.z, +p.x, +p.y) },
+// The 24 rotations are the signed permutation matrices with determinant +1.
+func generateRotations() []Rotation {
+	perms := [][3]int{
+		{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0},
+	}
 
-	func(p Point) Point { return MakePoint(+p.x, +p.z, -p.y) },
-	func(p Point) Point { return MakePoint(-p.z, +p.x, -p.y) },
-	func(p Point) Point { return MakePoint(-p.x, -p.z, -p.y) },
-	func(p Point) Point { return MakePoint(+p.z, -p.x, -p.y) },
+	out := make([]Rotation, 0, 24)
+	for _, perm := range perms {
+		for signs := 0; signs < 8; signs++ {
+			var r Rotation
+			for row, col := range perm {
+				r[row][col] = 1 - 2*((signs>>row)&1)
+			}
+			if r.Determinant() == 1 {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
 }
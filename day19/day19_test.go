@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRotations(t *testing.T) {
+	if len(rotations) != 24 {
+		t.Fatalf("got %d rotations, want 24", len(rotations))
+	}
+	seen := make(map[Rotation]bool)
+	for _, r := range rotations {
+		if seen[r] {
+			t.Errorf("rotation %v appears twice", r)
+		}
+		seen[r] = true
+		if r.Determinant() != 1 {
+			t.Errorf("rotation %v has determinant %d", r, r.Determinant())
+		}
+	}
+}
+
+func TestTransformRoundTrip(t *testing.T) {
+	p := MakePoint(7, -3, 11)
+	for i, r := range rotations {
+		transform := r.MakeTransform(MakePoint(i, -2*i, 100-i))
+
+		parsed, err := ParseTransform(transform.String())
+		if err != nil || parsed != transform {
+			t.Errorf("ParseTransform(%q) gave %v, %v", transform.String(), parsed, err)
+		}
+
+		inverse := transform.Inverse()
+		if got := transform.Compose(inverse); got != Identity {
+			t.Errorf("%v composed with its inverse gave %v", transform, got)
+		}
+		if got := inverse.Compose(transform); got != Identity {
+			t.Errorf("inverse of %v composed with it gave %v", transform, got)
+		}
+		if got := inverse.Apply(transform.Apply(p)); got != p {
+			t.Errorf("%v and its inverse took %v to %v", transform, p, got)
+		}
+	}
+}
+
+func TestParseTransformErrors(t *testing.T) {
+	for _, text := range []string{"", "1,0,0/0,1,0/0,0,1", "1,0,0/0,1,0/0,0,1@1,2", "a,0,0/0,1,0/0,0,1@0,0,0"} {
+		if _, err := ParseTransform(text); err == nil {
+			t.Errorf("ParseTransform(%q) should fail", text)
+		}
+	}
+}
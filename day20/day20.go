@@ -2,11 +2,27 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
+	"log"
+	"math/bits"
+	"runtime"
+	"strings"
+	"sync"
 )
 
+var bench = flag.Bool("bench", false, "benchmark byte images against bit-packed images")
+var rule = flag.String("rule", "", "use an outer-totalistic rule such as B3/S23 instead of the input's lookup table")
+var kernel = flag.Int("kernel", 3, "kernel size for -rule")
+
 func main() {
-	image, enhancer := ParseInput(aoc.GetFilename())
+	image, enhancer := ParseInput(aoc.GetFilename(), *rule, *kernel)
+
+	if *bench {
+		aoc.Benchmark("bytes", func() { EnhanceBytes(image, enhancer, 50) })
+		aoc.Benchmark("bits", func() { EnhanceBits(image, enhancer, 50) })
+		return
+	}
 
 	fmt.Println(part1(image, enhancer))
 	fmt.Println(part2(image, enhancer))
@@ -15,21 +31,30 @@ func main() {
 //------------------------------------------------------------------------------
 
 func part1(image Image, enhancer Enhancer) int {
-
-	for i := 0; i < 2; i++ {
-		image = image.Enhance(enhancer)
-	}
-
-	return image.LitPixels()
+	return EnhanceBits(image, enhancer, 2).LitPixels()
 }
 
 func part2(image Image, enhancer Enhancer) int {
+	return EnhanceBits(image, enhancer, 50).LitPixels()
+}
 
-	for i := 0; i < 50; i++ {
+func EnhanceBytes(image Image, enhancer Enhancer, steps int) Image {
+	for i := 0; i < steps; i++ {
 		image = image.Enhance(enhancer)
 	}
+	return image
+}
+
+func EnhanceBits(image Image, enhancer Enhancer, steps int) *BitImage {
+	r := enhancer.kernel / 2
+	src := MakeBitImage(image, r*steps, r)
+	dst := MakeBitImage(image, r*steps, r)
 
-	return image.LitPixels()
+	for i := 0; i < steps; i++ {
+		src.EnhanceInto(dst, enhancer)
+		src, dst = dst, src
+	}
+	return src
 }
 
 //------------------------------------------------------------------------------
@@ -41,21 +66,81 @@ const (
 	On        = '#'
 )
 
-func (this Pixel) Flip() Pixel {
-	if this == Off {
-		return On
-	} else {
-		return Off
-	}
-}
-
 type Image struct {
 	pixel        []Pixel
 	w, h         int
 	defaultPixel Pixel
 }
 
-type Enhancer []Pixel
+// A Rule maps a kernel-sized neighbourhood to the new pixel value. The
+// neighbourhood is read row by row, with the top-left pixel as the most
+// significant bit of the address.
+type Rule func(address uint64) Pixel
+
+type Enhancer struct {
+	kernel int
+	rule   Rule
+}
+
+// Addresses must fit into a uint64, so the largest kernel is 7x7.
+const MaxKernel = 7
+
+func checkKernel(kernel int) {
+	if kernel < 1 || kernel > MaxKernel || kernel%2 == 0 {
+		log.Fatalf("Kernel size must be odd and between 1 and %d, got %d", MaxKernel, kernel)
+	}
+}
+
+func MakeLookupEnhancer(table []Pixel) Enhancer {
+	kernel := 1
+	for (1 << (kernel * kernel)) < len(table) {
+		kernel += 2
+	}
+	checkKernel(kernel)
+	if (1 << (kernel * kernel)) != len(table) {
+		log.Fatalf("Enhancer length %d is not 2^(k*k) for odd k", len(table))
+	}
+
+	return Enhancer{kernel, func(address uint64) Pixel { return table[address] }}
+}
+
+// An outer-totalistic rule depends only on the centre pixel and the number of
+// lit pixels around it, eg. Conway's Life is MakeOuterTotalisticEnhancer(3,
+// []int{3}, []int{2, 3}).
+func MakeOuterTotalisticEnhancer(kernel int, born, survive []int) Enhancer {
+	checkKernel(kernel)
+
+	var bornSet, surviveSet uint64
+	for _, n := range born {
+		bornSet |= 1 << n
+	}
+	for _, n := range survive {
+		surviveSet |= 1 << n
+	}
+
+	centre := uint(kernel * kernel / 2)
+	return Enhancer{kernel, func(address uint64) Pixel {
+		lit := (address >> centre) & 1
+		neighbours := bits.OnesCount64(address) - int(lit)
+		set := bornSet
+		if lit == 1 {
+			set = surviveSet
+		}
+		if (set>>neighbours)&1 == 1 {
+			return On
+		}
+		return Off
+	}}
+}
+
+// The infinite background is uniform, so its next value is whatever the
+// rule gives for an all-off or all-on neighbourhood.
+func (this Enhancer) NextDefault(pixel Pixel) Pixel {
+	if pixel == Off {
+		return this.rule(0)
+	}
+	return this.rule(1<<(this.kernel*this.kernel) - 1)
+}
 
 func (this Image) Print(msg string) {
 	fmt.Println(msg)
@@ -82,10 +167,11 @@ func (this Image) GetPixel(x, y int) Pixel {
 }
 
 func (this Image) EnhancePixel(x, y int, enhancer Enhancer) Pixel {
-	address := 0
+	var address uint64
+	r := enhancer.kernel / 2
 
-	for dy := -1; dy <= 1; dy++ {
-		for dx := -1; dx <= 1; dx++ {
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
 			address = address << 1
 			if this.GetPixel(x+dx, y+dy) == On {
 				address |= 1
@@ -93,24 +179,25 @@ func (this Image) EnhancePixel(x, y int, enhancer Enhancer) Pixel {
 		}
 	}
 
-	return enhancer[address]
+	return enhancer.rule(address)
 }
 
 func (this Image) Enhance(enhancer Enhancer) Image {
-	w := this.w + 2
-	h := this.h + 2
+	r := enhancer.kernel / 2
+	w := this.w + 2*r
+	h := this.h + 2*r
 
-	pixels := make([]Pixel, w * h)
+	pixels := make([]Pixel, w*h)
 	i := 0
 
-	for y := -1; y <= this.h; y++ {
-		for x := -1; x <= this.w; x++ {
+	for y := -r; y < this.h+r; y++ {
+		for x := -r; x < this.w+r; x++ {
 			pixels[i] = this.EnhancePixel(x, y, enhancer)
 			i++
 		}
 	}
 
-	return Image{pixels, w, h, this.defaultPixel.Flip()}
+	return Image{pixels, w, h, enhancer.NextDefault(this.defaultPixel)}
 }
 
 func (this Image) LitPixels() int {
@@ -125,10 +212,17 @@ func (this Image) LitPixels() int {
 	return count
 }
 
-func ParseInput(filename string) (Image, Enhancer) {
-	lines := aoc.GetInputLines(aoc.GetFilename())
+// If rule is given, the lookup table on the first line is ignored.
+func ParseInput(filename, rule string, kernel int) (Image, Enhancer) {
+	lines := aoc.GetInputLines(filename)
 
-	enhancer := ParseEnhancer(lines[0])
+	var enhancer Enhancer
+	if rule != "" {
+		born, survive := ParseRule(rule)
+		enhancer = MakeOuterTotalisticEnhancer(kernel, born, survive)
+	} else {
+		enhancer = ParseEnhancer(lines[0])
+	}
 	image := ParseImage(lines[2:])
 
 	return image, enhancer
@@ -151,10 +245,189 @@ func ParseImage(lines []string) Image {
 	return Image{pixels, w, h, Off}
 }
 
+// ParseRule reads a rule in B/S notation, eg. "B3/S23" for Life. Counts of
+// ten or more, for bigger kernels, need commas between them: "B3,12/S2,3".
+func ParseRule(text string) ([]int, []int) {
+	parts := strings.Split(text, "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "B") || !strings.HasPrefix(parts[1], "S") {
+		log.Fatalf("Expecting a rule like B3/S23, got %q", text)
+	}
+	return parseCounts(parts[0][1:]), parseCounts(parts[1][1:])
+}
+
+func parseCounts(text string) []int {
+	if text == "" {
+		return []int{}
+	}
+	if strings.Contains(text, ",") {
+		return aoc.ParseInts(strings.Split(text, ","))
+	}
+	return aoc.ParseInts(strings.Split(text, ""))
+}
+
 func ParseEnhancer(line string) Enhancer {
-	enhancer := make([]Pixel, len(line))
+	table := make([]Pixel, len(line))
 	for i, char := range line {
-		enhancer[i] = Pixel(char)
+		table[i] = Pixel(char)
+	}
+	return MakeLookupEnhancer(table)
+}
+
+//------------------------------------------------------------------------------
+
+// A BitImage holds pixels one bit each, on a canvas big enough for every
+// step of the enhancement. Pixels outside the current image hold the default
+// value, and the canvas has pad extra pixels on each side so that kernels
+// never need bounds checks.
+type BitImage struct {
+	bits         []uint64
+	w, h         int // canvas size, including padding
+	stride       int // words per row
+	pad          int
+	defaultPixel Pixel
+
+	// The part of the canvas corresponding to the current Image.
+	x0, y0, x1, y1 int
+}
+
+func MakeBitImage(image Image, margin, pad int) *BitImage {
+	w := image.w + 2*(margin+pad)
+	h := image.h + 2*(margin+pad)
+	stride := (w + 63) / 64
+
+	this := &BitImage{
+		bits:         make([]uint64, stride*h),
+		w:            w,
+		h:            h,
+		stride:       stride,
+		pad:          pad,
+		defaultPixel: image.defaultPixel,
+		x0:           margin + pad,
+		y0:           margin + pad,
+		x1:           margin + pad + image.w,
+		y1:           margin + pad + image.h,
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			this.SetPixel(x, y, image.GetPixel(x-this.x0, y-this.y0))
+		}
+	}
+	return this
+}
+
+func (this *BitImage) bit(x, y int) uint64 {
+	return (this.bits[y*this.stride+x>>6] >> (x & 63)) & 1
+}
+
+func (this *BitImage) SetPixel(x, y int, pixel Pixel) {
+	word := &this.bits[y*this.stride+x>>6]
+	mask := uint64(1) << (x & 63)
+	if pixel == On {
+		*word |= mask
+	} else {
+		*word &^= mask
+	}
+}
+
+// EnhanceInto writes the next step into dst, which must have the same size
+// canvas. Rows are split into stripes which are enhanced
+// in parallel.
+func (this *BitImage) EnhanceInto(dst *BitImage, enhancer Enhancer) {
+	k := enhancer.kernel
+	r := k / 2
+	if r > this.pad {
+		log.Fatalf("Kernel %d needs padding of %d, have %d", k, r, this.pad)
+	}
+
+	// Small kernels get a precomputed table rather than calling the rule.
+	var table []Pixel
+	if k*k <= 16 {
+		table = make([]Pixel, 1<<(k*k))
+		for address := range table {
+			table[address] = enhancer.rule(uint64(address))
+		}
+	}
+
+	// Sliding the window right shifts every row of the address left by one.
+	// keep clears the bits which fall off the left of each row, and column
+	// holds the positions where the new right-hand column is inserted.
+	var keep uint64
+	column := make([]uint, k)
+	for i := 0; i < k; i++ {
+		keep |= ((1 << k) - 2) << (i * k)
+		column[i] = uint((k - 1 - i) * k)
 	}
-	return enhancer
+
+	dst.defaultPixel = enhancer.NextDefault(this.defaultPixel)
+	dst.x0, dst.y0 = this.x0-r, this.y0-r
+	dst.x1, dst.y1 = this.x1+r, this.y1+r
+	if dst.x0 < this.pad || dst.y0 < this.pad {
+		log.Fatal("Enhanced image has outgrown its canvas")
+	}
+
+	// Everything outside the new image is the new default.
+	var fill uint64
+	if dst.defaultPixel == On {
+		fill = ^fill
+	}
+	for i := range dst.bits {
+		dst.bits[i] = fill
+	}
+
+	enhanceRow := func(y int) {
+		rowBase := make([]int, k)
+		for i := range rowBase {
+			rowBase[i] = (y - r + i) * this.stride
+		}
+
+		var address uint64
+		for x := dst.x0 - r; x < dst.x1+r; x++ {
+			address = (address << 1) & keep
+			word, shift := x>>6, uint(x&63)
+			for i, base := range rowBase {
+				address |= ((this.bits[base+word] >> shift) & 1) << column[i]
+			}
+			if x < dst.x0+r {
+				continue
+			}
+
+			var pixel Pixel
+			if table != nil {
+				pixel = table[address]
+			} else {
+				pixel = enhancer.rule(address)
+			}
+			dst.SetPixel(x-r, y, pixel)
+		}
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	stripe := (dst.y1 - dst.y0 + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for start := dst.y0; start < dst.y1; start += stripe {
+		end := start + stripe
+		if end > dst.y1 {
+			end = dst.y1
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for y := start; y < end; y++ {
+				enhanceRow(y)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+func (this *BitImage) LitPixels() int {
+	count := 0
+	for y := this.y0; y < this.y1; y++ {
+		for x := this.x0; x < this.x1; x++ {
+			count += int(this.bit(x, y))
+		}
+	}
+	return count
 }
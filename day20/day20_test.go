@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLookupEnhancer(t *testing.T) {
+	image, enhancer := ParseInput("example01.txt", "", 0)
+	if got := part1(image, enhancer); got != 35 {
+		t.Errorf("part 1: got %d, want 35", got)
+	}
+	if got := part2(image, enhancer); got != 3351 {
+		t.Errorf("part 2: got %d, want 3351", got)
+	}
+}
+
+// A Life blinker flips between a vertical and a horizontal line of three.
+func TestOuterTotalisticBlinker(t *testing.T) {
+	image := ParseImage([]string{".#.", ".#.", ".#."})
+	enhancer := MakeOuterTotalisticEnhancer(3, []int{3}, []int{2, 3})
+
+	for steps := 1; steps <= 4; steps++ {
+		// Each step grows the image by a pixel on every side.
+		enhanced := EnhanceBytes(image, enhancer, steps)
+		for y := -1; y <= 3; y++ {
+			for x := -1; x <= 3; x++ {
+				want := Pixel(Off)
+				if (steps%2 == 0 && x == 1 && y >= 0 && y <= 2) ||
+					(steps%2 == 1 && y == 1 && x >= 0 && x <= 2) {
+					want = On
+				}
+				if got := enhanced.GetPixel(x+steps, y+steps); got != want {
+					t.Errorf("step %d: pixel (%d,%d) is %c, want %c", steps, x, y, got, want)
+				}
+			}
+		}
+
+		if got := EnhanceBits(image, enhancer, steps).LitPixels(); got != 3 {
+			t.Errorf("step %d: %d bit image pixels lit, want 3", steps, got)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	born, survive := ParseRule("B36/S23")
+	if len(born) != 2 || born[0] != 3 || born[1] != 6 || len(survive) != 2 || survive[0] != 2 || survive[1] != 3 {
+		t.Errorf("B36/S23 gave born %v, survive %v", born, survive)
+	}
+	born, survive = ParseRule("B3,12/S")
+	if len(born) != 2 || born[0] != 3 || born[1] != 12 || len(survive) != 0 {
+		t.Errorf("B3,12/S gave born %v, survive %v", born, survive)
+	}
+}
@@ -2,125 +2,225 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"encoding/csv"
+	"flag"
 	"fmt"
+	"log"
+	"math/big"
+	"os"
 	"strings"
 )
 
+var boardSize = flag.Int("board", 10, "number of spaces on the board")
+var rollsPerTurn = flag.Int("rolls", 3, "rolls per turn")
+var dieFaces = flag.Int("die", 100, "faces on the deterministic die")
+var target = flag.Int("target", 1000, "winning score with the deterministic die")
+var diracFaces = flag.Int("dirac", 3, "faces on the Dirac die")
+var diracTarget = flag.Int("dirac-target", 21, "winning score with the Dirac die")
+var table = flag.String("table", "", "write Dirac outcomes for every combination of start positions to this CSV file")
+
 func main() {
 	startPositions := parseInput(aoc.GetFilename())
 
-	fmt.Println(part1(startPositions))
-	fmt.Println(part2(startPositions))
+	deterministic := Game{
+		boardSize:    *boardSize,
+		dieFaces:     *dieFaces,
+		rollsPerTurn: *rollsPerTurn,
+		winningScore: *target,
+	}
+	dirac := Game{
+		boardSize:    *boardSize,
+		dieFaces:     *diracFaces,
+		rollsPerTurn: *rollsPerTurn,
+		winningScore: *diracTarget,
+	}
+
+	fmt.Println(part1(deterministic, startPositions))
+	fmt.Println(part2(dirac, startPositions))
+
+	if *table != "" {
+		aoc.CheckErr(writeTable(*table, dirac, len(startPositions)))
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// A Game describes the board and die. Any number of players may play, each
+// taking rollsPerTurn rolls per turn.
+type Game struct {
+	boardSize    int
+	dieFaces     int
+	rollsPerTurn int
+	winningScore int
+}
+
+// Limits imposed by the fixed-size Game2 state used for the multiverse.
+const (
+	MaxPlayers  = 6
+	MaxPosition = 0xffff
+	MaxScore    = 0xffff
+)
+
+func (this Game) Check(startPositions []int) {
+	players := len(startPositions)
+	if players < 1 || players > MaxPlayers {
+		log.Fatalf("Need between 1 and %d players, got %d", MaxPlayers, players)
+	}
+	if this.boardSize < 1 || this.boardSize > MaxPosition {
+		log.Fatalf("Board size must be between 1 and %d, got %d", MaxPosition, this.boardSize)
+	}
+	if this.dieFaces < 1 || this.rollsPerTurn < 1 {
+		log.Fatalf("Need at least one die face and one roll per turn")
+	}
+	if this.winningScore < 1 || this.winningScore+this.boardSize > MaxScore {
+		log.Fatalf("Winning score must be between 1 and %d, got %d",
+			MaxScore-this.boardSize, this.winningScore)
+	}
+	for _, position := range startPositions {
+		if position < 1 || position > this.boardSize {
+			log.Fatalf("Start position %d is not on the board", position)
+		}
+	}
+}
+
+func (this Game) Move(position, roll int) int {
+	return ((position + roll - 1) % this.boardSize) + 1
 }
 
 //------------------------------------------------------------------------------
 
-func part1(startPositions [2]int) int {
-	player := [2]Player{
-		MakePlayer(startPositions[0]),
-		MakePlayer(startPositions[1]),
+func part1(game Game, startPositions []int) int {
+	game.Check(startPositions)
+
+	player := make([]Player, len(startPositions))
+	for i, position := range startPositions {
+		player[i] = MakePlayer(position)
 	}
 
-	dice := MakeDice(100)
+	dice := MakeDice(game.dieFaces)
+
+	for next := 0; ; next = (next + 1) % len(player) {
+		player[next].TakeTurn(game, &dice)
+		if player[next].score >= game.winningScore {
+			return lowestScore(player) * dice.rolls
+		}
+	}
+}
 
-	for next := 0; ; next ^= 1 {
-		player[next].TakeTurn(&dice)
-		if player[next].score >= 1000 {
-			loser := next ^ 1
-			return player[loser].score * dice.rolls
+func lowestScore(player []Player) int {
+	lowest := player[0].score
+	for _, p := range player[1:] {
+		if p.score < lowest {
+			lowest = p.score
 		}
 	}
+	return lowest
 }
 
 //------------------------------------------------------------------------------
 
-func part2(startPositions [2]int) uint64 {
-	game := Game2{player: [...]Player2{
-		MakePlayer2(uint8(startPositions[0])),
-		MakePlayer2(uint8(startPositions[1])),
-	}}
+func part2(game Game, startPositions []int) *big.Int {
+	game.Check(startPositions)
 
-	rolls := MakeRolls()
+	var start Game2
+	for i, position := range startPositions {
+		start.player[i] = MakePlayer2(uint16(position))
+	}
+
+	rolls := MakeRolls(game.dieFaces, game.rollsPerTurn)
 
 	multiverse := make(Multiverse)
-	multiverse[game] = 1
+	multiverse[start] = big.NewInt(1)
 
-	scores := Scores{}
+	scores := Scores{wins: make([]*big.Int, len(startPositions))}
+	for i := range scores.wins {
+		scores.wins[i] = new(big.Int)
+	}
 
-	for player := 0; len(multiverse) > 0; player ^= 1 {
-		multiverse = TakeTurn(player, multiverse, rolls, &scores)
+	for player := 0; len(multiverse) > 0; player = (player + 1) % len(startPositions) {
+		multiverse = TakeTurn(game, player, multiverse, rolls, &scores)
 	}
 
-	if scores.wins[0] > scores.wins[1] {
-		return scores.wins[0]
-	} else {
-		return scores.wins[1]
+	best := scores.wins[0]
+	for _, wins := range scores.wins[1:] {
+		if wins.Cmp(best) > 0 {
+			best = wins
+		}
 	}
+	return best
 }
 
 type Roll struct {
-	score, count uint8
+	score int
+	count *big.Int
 }
 
-type Multiverse map[Game2]uint64
-
-type Wins [2]uint64
+// Universe counts grow quickly with more players or faces, so are kept as
+// big integers.
+type Multiverse map[Game2]*big.Int
 
 type Game2 struct {
-	player [2]Player2
+	player [MaxPlayers]Player2
 }
 
 type Player2 struct {
-	position uint8
-	score    uint8
+	position uint16
+	score    uint16
 }
 
 type Scores struct {
-	wins [2]uint64
+	wins []*big.Int
 }
 
-func MakePlayer2(position uint8) Player2 {
+func MakePlayer2(position uint16) Player2 {
 	return Player2{position: position, score: 0}
 }
 
-func MakeRolls() []Roll {
-	var hist [10]uint8
+func MakeRolls(faces, rollsPerTurn int) []Roll {
+	hist := []*big.Int{big.NewInt(1)}
 
-	for i := 1; i <= 3; i++ {
-		for j := 1; j <= 3; j++ {
-			for k := 1; k <= 3; k++ {
-				hist[i+j+k]++
+	for i := 0; i < rollsPerTurn; i++ {
+		next := make([]*big.Int, len(hist)+faces)
+		for score := range next {
+			next[score] = new(big.Int)
+		}
+		for score, count := range hist {
+			for face := 1; face <= faces; face++ {
+				next[score+face].Add(next[score+face], count)
 			}
 		}
+		hist = next
 	}
 
 	rolls := make([]Roll, 0)
 	for score, count := range hist {
-		if count > 0 {
-			rolls = append(rolls, Roll{uint8(score), count})
+		if count.Sign() > 0 {
+			rolls = append(rolls, Roll{score, count})
 		}
 	}
 	return rolls
 }
 
-func TakeTurn(whichPlayer int, from Multiverse, rolls []Roll, scores *Scores) Multiverse {
+func TakeTurn(game Game, whichPlayer int, from Multiverse, rolls []Roll, scores *Scores) Multiverse {
 
 	to := make(Multiverse)
+	totalCount := new(big.Int)
 
-	for game, gameCount := range from {
+	for state, stateCount := range from {
 		for _, roll := range rolls {
-			player := game.player[whichPlayer].Move(roll.score)
+			player := state.player[whichPlayer].Move(game, roll.score)
 
-			totalCount := gameCount * uint64(roll.count)
+			totalCount.Mul(stateCount, roll.count)
 
-			if player.score >= 21 {
-				scores.wins[whichPlayer] += totalCount
+			if int(player.score) >= game.winningScore {
+				scores.wins[whichPlayer].Add(scores.wins[whichPlayer], totalCount)
 			} else {
-				nextGame := game.NextGame(whichPlayer, player)
-				if count, found := to[nextGame]; found {
-					totalCount += count
+				nextState := state.NextGame(whichPlayer, player)
+				if count, found := to[nextState]; found {
+					count.Add(count, totalCount)
+				} else {
+					to[nextState] = new(big.Int).Set(totalCount)
 				}
-				to[nextGame] = totalCount
 			}
 		}
 	}
@@ -129,20 +229,167 @@ func TakeTurn(whichPlayer int, from Multiverse, rolls []Roll, scores *Scores) Mu
 }
 
 func (this *Game2) NextGame(whichPlayer int, player Player2) Game2 {
-	if whichPlayer == 0 {
-		return Game2{player: [...]Player2{player, this.player[1]}}
-	} else {
-		return Game2{player: [...]Player2{this.player[0], player}}
-	}
+	next := *this
+	next.player[whichPlayer] = player
+	return next
 }
 
-func (this *Player2) Move(roll uint8) Player2 {
-	position := ((this.position + roll - 1) % 10) + 1
+func (this *Player2) Move(game Game, roll int) Player2 {
+	position := uint16(game.Move(int(this.position), roll))
 	return Player2{position: position, score: this.score + position}
 }
 
 //------------------------------------------------------------------------------
 
+// An Outcome summarises every universe that follows from some state: how
+// many of them each player wins, each player's chance of winning, and the
+// expected number of turns still to play. Each turn is equally likely to be
+// any of the universes it splits into, so short games are more likely than
+// their share of the universe count.
+type Outcome struct {
+	wins        []*big.Int
+	probability []*big.Rat
+	turns       *big.Rat
+}
+
+type Turn struct {
+	state Game2
+	next  int
+}
+
+type Outcomes struct {
+	game      Game
+	players   int
+	rolls     []Roll
+	universes *big.Int // universes each turn splits into
+	memo      map[Turn]Outcome
+}
+
+func MakeOutcomes(game Game, players int) Outcomes {
+	outcomes := Outcomes{
+		game:      game,
+		players:   players,
+		rolls:     MakeRolls(game.dieFaces, game.rollsPerTurn),
+		universes: new(big.Int),
+		memo:      make(map[Turn]Outcome),
+	}
+	for _, roll := range outcomes.rolls {
+		outcomes.universes.Add(outcomes.universes, roll.count)
+	}
+	return outcomes
+}
+
+// Start gives the outcome of a game with the given start positions.
+func (this *Outcomes) Start(startPositions []int) Outcome {
+	this.game.Check(startPositions)
+
+	var start Game2
+	for i, position := range startPositions {
+		start.player[i] = MakePlayer2(uint16(position))
+	}
+	return this.From(start, 0)
+}
+
+// From gives the outcome once it is player next's turn in state. Outcomes
+// are remembered, as many different games pass through the same states.
+func (this *Outcomes) From(state Game2, next int) Outcome {
+	turn := Turn{state: state, next: next}
+	if outcome, found := this.memo[turn]; found {
+		return outcome
+	}
+
+	outcome := Outcome{
+		wins:        make([]*big.Int, this.players),
+		probability: make([]*big.Rat, this.players),
+		turns:       big.NewRat(1, 1),
+	}
+	for i := range outcome.wins {
+		outcome.wins[i] = new(big.Int)
+		outcome.probability[i] = new(big.Rat)
+	}
+
+	count := new(big.Int)
+	term := new(big.Rat)
+	for _, roll := range this.rolls {
+		chance := new(big.Rat).SetFrac(roll.count, this.universes)
+		player := state.player[next].Move(this.game, roll.score)
+
+		if int(player.score) >= this.game.winningScore {
+			outcome.wins[next].Add(outcome.wins[next], roll.count)
+			outcome.probability[next].Add(outcome.probability[next], chance)
+			continue
+		}
+
+		rest := this.From(state.NextGame(next, player), (next+1)%this.players)
+		for i := range outcome.wins {
+			outcome.wins[i].Add(outcome.wins[i], count.Mul(roll.count, rest.wins[i]))
+			outcome.probability[i].Add(outcome.probability[i], term.Mul(chance, rest.probability[i]))
+		}
+		outcome.turns.Add(outcome.turns, term.Mul(chance, rest.turns))
+	}
+
+	this.memo[turn] = outcome
+	return outcome
+}
+
+// writeTable writes the outcome for every combination of start positions,
+// one row each.
+func writeTable(filename string, game Game, players int) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out := csv.NewWriter(file)
+
+	header := make([]string, 0, 3*players+1)
+	for _, column := range []string{"start", "wins", "probability"} {
+		for i := 1; i <= players; i++ {
+			header = append(header, fmt.Sprintf("player%d_%s", i, column))
+		}
+	}
+	out.Write(append(header, "expected_turns"))
+
+	outcomes := MakeOutcomes(game, players)
+	startPositions := make([]int, players)
+	for i := range startPositions {
+		startPositions[i] = 1
+	}
+	for {
+		outcome := outcomes.Start(startPositions)
+
+		row := make([]string, 0, len(header)+1)
+		for _, position := range startPositions {
+			row = append(row, fmt.Sprint(position))
+		}
+		for _, wins := range outcome.wins {
+			row = append(row, fmt.Sprint(wins))
+		}
+		for _, probability := range outcome.probability {
+			row = append(row, probability.FloatString(12))
+		}
+		out.Write(append(row, outcome.turns.FloatString(6)))
+
+		// Step to the next combination, last player fastest.
+		i := players - 1
+		for ; i >= 0; i-- {
+			if startPositions[i]++; startPositions[i] <= game.boardSize {
+				break
+			}
+			startPositions[i] = 1
+		}
+		if i < 0 {
+			break
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+//------------------------------------------------------------------------------
+
 type Player struct {
 	position int
 	score    int
@@ -152,13 +399,13 @@ func MakePlayer(position int) Player {
 	return Player{position: position, score: 0}
 }
 
-func (this *Player) TakeTurn(dice *Dice) {
+func (this *Player) TakeTurn(game Game, dice *Dice) {
 	roll := 0
-	for i := 0; i < 3; i++ {
+	for i := 0; i < game.rollsPerTurn; i++ {
 		roll += dice.Roll()
 	}
 
-	this.position = ((this.position + roll - 1) % 10) + 1
+	this.position = game.Move(this.position, roll)
 	this.score += this.position
 }
 
@@ -185,13 +432,16 @@ func (this *Dice) Roll() int {
 
 //------------------------------------------------------------------------------
 
-func parseInput(filename string) [2]int {
+func parseInput(filename string) []int {
 	lines := aoc.GetInputLines(filename)
 
-	var numbers [2]int
-	for i := range numbers {
-		parts := strings.Split(lines[i], ": ")
-		numbers[i] = aoc.ParseInt(parts[1])
+	numbers := make([]int, 0, len(lines))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		parts := strings.Split(line, ": ")
+		numbers = append(numbers, aoc.ParseInt(parts[1]))
 	}
 
 	return numbers
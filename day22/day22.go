@@ -2,54 +2,432 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"bufio"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"math/bits"
 	"regexp"
 	"sort"
+	"strings"
 )
 
+var objFile = flag.String("obj", "", "write the lit cuboids to this Wavefront OBJ file")
+var stlFile = flag.String("stl", "", "write the lit cuboids to this ASCII STL file")
+var backendName = flag.String("backend", "split", "solver backend: split, signed or compress")
+var check = flag.Bool("check", false, "cross-check every backend against the others")
+var bench = flag.Bool("bench", false, "benchmark every backend")
+var query = flag.String("query", "", "count the lit cubes within these ranges, eg. x=0..9,y=0..9,z=0..9")
+var point = flag.String("point", "", "report whether the cube at these coordinates is lit, eg. 1,2,3")
+var list = flag.Bool("list", false, "list the disjoint cuboids making up the lit cubes")
+
 func main() {
 	steps := ParseInput(aoc.GetFilename())
 
-	fmt.Println(part1(MakeCube(50), steps))
-	fmt.Println(part2(steps))
+	if *check {
+		checkBackends(steps)
+		return
+	}
+	if *bench {
+		benchBackends(steps)
+		return
+	}
+
+	backend, found := Backends[*backendName]
+	if !found {
+		log.Fatalf("Unknown backend %q", *backendName)
+	}
+
+	fmt.Println(part1(MakeCube(Dims(steps), 50), steps, backend))
+	fmt.Println(part2(steps, backend))
+
+	if *objFile != "" || *stlFile != "" || *query != "" || *point != "" || *list {
+		reactor := MakeReactor()
+		reactor.ApplyAll(steps)
+		if *list {
+			for _, cuboid := range reactor.Cuboids() {
+				fmt.Printf("%#v\n", cuboid)
+			}
+		}
+		if *query != "" {
+			cuboid := ParseCuboid(*query)
+			checkDims(cuboid.dims, Dims(steps))
+			fmt.Println(reactor.LitVolumeIn(cuboid))
+		}
+		if *point != "" {
+			p, dims := ParsePoint(*point)
+			checkDims(dims, Dims(steps))
+			fmt.Println(reactor.IsOn(p))
+		}
+		if *objFile != "" {
+			aoc.CheckErr(aoc.WriteFile(*objFile, reactor.WriteOBJ))
+		}
+		if *stlFile != "" {
+			aoc.CheckErr(aoc.WriteFile(*stlFile, reactor.WriteSTL))
+		}
+	}
 }
 
-func part1(bounds Cuboid, steps []Step) int {
-	cuboids := make([]Cuboid, 0)
+func part1(bounds Cuboid, steps []Step, backend Backend) int {
+	clipped := make([]Step, 0, len(steps))
 
 	for _, step := range steps {
-		if step.cuboid.Overlaps(&bounds) {
-			cuboids = SplitCuboids(cuboids, step.cuboid)
-			if step.isOn {
-				cuboids = append(cuboids, step.cuboid)
+		if cuboid, found := step.cuboid.Intersect(&bounds); found {
+			clipped = append(clipped, Step{cuboid: cuboid, isOn: step.isOn})
+		}
+	}
+
+	return backend(clipped).volume
+}
+
+func part2(steps []Step, backend Backend) int {
+	return backend(steps).volume
+}
+
+func checkBackends(steps []Step) {
+	bounds := MakeCube(Dims(steps), 50)
+	for _, part := range []func(Backend) int{
+		func(backend Backend) int { return part1(bounds, steps, backend) },
+		func(backend Backend) int { return part2(steps, backend) },
+	} {
+		expected := part(Backends[BackendNames[0]])
+		for _, name := range BackendNames[1:] {
+			if got := part(Backends[name]); got != expected {
+				log.Fatalf("Backend %s gave %d, %s gave %d", name, got, BackendNames[0], expected)
 			}
 		}
+		fmt.Println(expected)
 	}
+}
 
-	total := 0
-	for _, cuboid := range cuboids {
-		total += cuboid.Size()
+func benchBackends(steps []Step) {
+	for _, name := range BackendNames {
+		backend := Backends[name]
+		result := backend(steps)
+		aoc.Benchmark(fmt.Sprintf("%s (%d fragments)", name, result.fragments),
+			func() { backend(steps) })
 	}
-	return total
 }
 
-func part2(steps []Step) int {
-	cuboids := make([]Cuboid, 0)
+func checkDims(dims, expected int) {
+	if dims != expected {
+		log.Fatalf("Expected %d dimensions to match the steps, got %d", expected, dims)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// A Backend applies all the steps and reports the lit volume, along with how
+// many pieces it needed to keep track of along the way.
+type Backend func(steps []Step) Result
+
+type Result struct {
+	volume    int
+	fragments int
+}
+
+var BackendNames = []string{"split", "signed", "compress"}
+
+var Backends = map[string]Backend{
+	"split":    SolveSplit,
+	"signed":   SolveSigned,
+	"compress": SolveCompressed,
+}
+
+func SolveSplit(steps []Step) Result {
+	reactor := MakeReactor()
+	reactor.ApplyAll(steps)
+	return Result{volume: reactor.LitVolume(), fragments: len(reactor.cuboids)}
+}
+
+// SolveSigned uses inclusion-exclusion. Every cuboid carries a count, and
+// each step cancels out its overlap with every existing cuboid by adding
+// the intersection with the opposite count.
+func SolveSigned(steps []Step) Result {
+	type Signed map[Cuboid]int
+	signed := make(Signed)
 
 	for _, step := range steps {
-		cuboids = SplitCuboids(cuboids, step.cuboid)
+		update := make(Signed)
+		for cuboid, count := range signed {
+			if overlap, found := cuboid.Intersect(&step.cuboid); found {
+				update[overlap] -= count
+			}
+		}
 		if step.isOn {
-			cuboids = append(cuboids, step.cuboid)
+			update[step.cuboid]++
+		}
+
+		for cuboid, count := range update {
+			if total := signed[cuboid] + count; total == 0 {
+				delete(signed, cuboid)
+			} else {
+				signed[cuboid] = total
+			}
+		}
+	}
+
+	volume := 0
+	for cuboid, count := range signed {
+		volume += count * cuboid.Size()
+	}
+	return Result{volume: volume, fragments: len(signed)}
+}
+
+// SolveCompressed maps every distinct cuboid boundary onto a small grid,
+// one bit per cell, and weights each cell by the volume it represents. The
+// last axis is packed into words, the others index rows of words.
+func SolveCompressed(steps []Step) Result {
+	if len(steps) == 0 {
+		return Result{}
+	}
+	dims := steps[0].cuboid.dims
+
+	axes := make([][]int, dims)
+	for axis := range axes {
+		seen := make(map[int]bool)
+		for _, step := range steps {
+			for _, v := range []int{step.cuboid.min.v[axis], step.cuboid.max.v[axis]} {
+				if !seen[v] {
+					seen[v] = true
+					axes[axis] = append(axes[axis], v)
+				}
+			}
+		}
+		sort.Ints(axes[axis])
+	}
+
+	size := make([]int, dims)
+	cells := 1
+	for axis := range size {
+		size[axis] = len(axes[axis]) - 1
+		cells *= size[axis]
+	}
+
+	last := dims - 1
+	stride := (size[last] + 63) / 64
+	rowIndex := func(index []int) int {
+		row := 0
+		for axis, i := range index {
+			row = row*size[axis] + i
 		}
+		return row * stride
 	}
 
+	grid := make([]uint64, cells/size[last]*stride)
+
+	for _, step := range steps {
+		lo := make([]int, dims)
+		hi := make([]int, dims)
+		for axis := range lo {
+			lo[axis] = sort.SearchInts(axes[axis], step.cuboid.min.v[axis])
+			hi[axis] = sort.SearchInts(axes[axis], step.cuboid.max.v[axis])
+		}
+		forEachIndex(lo[:last], hi[:last], func(index []int) {
+			setBits(grid[rowIndex(index):], lo[last], hi[last], step.isOn)
+		})
+	}
+
+	volume := 0
+	forEachIndex(make([]int, last), size[:last], func(index []int) {
+		area := 1
+		for axis, i := range index {
+			area *= axes[axis][i+1] - axes[axis][i]
+		}
+		row := grid[rowIndex(index):]
+		for w := 0; w < stride; w++ {
+			for word := row[w]; word != 0; word &= word - 1 {
+				i := w*64 + bits.TrailingZeros64(word)
+				volume += area * (axes[last][i+1] - axes[last][i])
+			}
+		}
+	})
+
+	return Result{volume: volume, fragments: cells}
+}
+
+// forEachIndex calls f with every index between lo (inclusive) and hi
+// (exclusive), with the last axis varying fastest.
+func forEachIndex(lo, hi []int, f func(index []int)) {
+	for axis := range lo {
+		if lo[axis] >= hi[axis] {
+			return
+		}
+	}
+
+	index := append([]int(nil), lo...)
+	for {
+		f(index)
+
+		axis := len(index) - 1
+		for ; axis >= 0; axis-- {
+			if index[axis]++; index[axis] < hi[axis] {
+				break
+			}
+			index[axis] = lo[axis]
+		}
+		if axis < 0 {
+			return
+		}
+	}
+}
+
+// setBits sets or clears bits lo up to, but not including, hi.
+func setBits(row []uint64, lo, hi int, on bool) {
+	for lo < hi {
+		w := lo >> 6
+		end := (w + 1) * 64
+		if end > hi {
+			end = hi
+		}
+		mask := (^uint64(0) >> (64 - (end - lo))) << (lo & 63)
+		if on {
+			row[w] |= mask
+		} else {
+			row[w] &^= mask
+		}
+		lo = end
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// A Reactor keeps the lit cubes as a list of disjoint cuboids.
+type Reactor struct {
+	cuboids []Cuboid
+}
+
+func MakeReactor() Reactor {
+	return Reactor{cuboids: make([]Cuboid, 0)}
+}
+
+func (this *Reactor) Apply(step Step) {
+	this.cuboids = SplitCuboids(this.cuboids, step.cuboid)
+	if step.isOn {
+		this.cuboids = append(this.cuboids, step.cuboid)
+	}
+}
+
+func (this *Reactor) ApplyAll(steps []Step) {
+	for _, step := range steps {
+		this.Apply(step)
+	}
+}
+
+// Cuboids returns the disjoint cuboids which make up the lit cubes.
+func (this *Reactor) Cuboids() []Cuboid {
+	return append([]Cuboid(nil), this.cuboids...)
+}
+
+func (this *Reactor) LitVolume() int {
 	total := 0
-	for _, cuboid := range cuboids {
+	for _, cuboid := range this.cuboids {
 		total += cuboid.Size()
 	}
 	return total
 }
 
+// LitVolumeIn counts the lit cubes inside the query cuboid.
+func (this *Reactor) LitVolumeIn(query Cuboid) int {
+	total := 0
+	for _, cuboid := range this.cuboids {
+		if overlap, found := cuboid.Intersect(&query); found {
+			total += overlap.Size()
+		}
+	}
+	return total
+}
+
+func (this *Reactor) IsOn(p Point) bool {
+	for _, cuboid := range this.cuboids {
+		if cuboid.Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteOBJ writes each lit cuboid as a box with eight vertices and six quads.
+// Cube (x,y,z) occupies the space from (x,y,z) to (x+1,y+1,z+1).
+func (this *Reactor) WriteOBJ(w io.Writer) error {
+	if err := this.checkMeshable(); err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+
+	for i, cuboid := range this.cuboids {
+		fmt.Fprintf(out, "o cuboid%d\n", i)
+		for _, corner := range cuboid.Corners() {
+			fmt.Fprintf(out, "v %d %d %d\n", corner.v[0], corner.v[1], corner.v[2])
+		}
+		base := i*8 + 1 // OBJ vertex indices are one-based
+		for _, face := range BoxFaces {
+			fmt.Fprintf(out, "f %d %d %d %d\n",
+				base+face[0], base+face[1], base+face[2], base+face[3])
+		}
+	}
+
+	return out.Flush()
+}
+
+// WriteSTL writes each lit cuboid as twelve triangles.
+func (this *Reactor) WriteSTL(w io.Writer) error {
+	if err := this.checkMeshable(); err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "solid reactor")
+	for _, cuboid := range this.cuboids {
+		corners := cuboid.Corners()
+		for f, face := range BoxFaces {
+			normal := BoxNormals[f]
+			for _, tri := range [2][3]int{{0, 1, 2}, {0, 2, 3}} {
+				fmt.Fprintf(out, "facet normal %d %d %d\n", normal[0], normal[1], normal[2])
+				fmt.Fprintln(out, "  outer loop")
+				for _, t := range tri {
+					corner := corners[face[t]]
+					fmt.Fprintf(out, "    vertex %d %d %d\n", corner.v[0], corner.v[1], corner.v[2])
+				}
+				fmt.Fprintln(out, "  endloop")
+				fmt.Fprintln(out, "endfacet")
+			}
+		}
+	}
+	fmt.Fprintln(out, "endsolid reactor")
+
+	return out.Flush()
+}
+
+func (this *Reactor) checkMeshable() error {
+	for _, cuboid := range this.cuboids {
+		if cuboid.dims != 3 {
+			return fmt.Errorf("can only export 3D cuboids, not %dD", cuboid.dims)
+		}
+	}
+	return nil
+}
+
+// Corner i of a box has bit 0 set for max x, bit 1 for max y, bit 2 for max z.
+// The faces are listed counter-clockwise when viewed from outside.
+var BoxFaces = [6][4]int{
+	{0, 4, 6, 2}, // -x
+	{1, 3, 7, 5}, // +x
+	{0, 1, 5, 4}, // -y
+	{2, 6, 7, 3}, // +y
+	{0, 2, 3, 1}, // -z
+	{4, 5, 7, 6}, // +z
+}
+
+var BoxNormals = [6][3]int{
+	{-1, 0, 0}, {1, 0, 0},
+	{0, -1, 0}, {0, 1, 0},
+	{0, 0, -1}, {0, 0, 1},
+}
+
+//------------------------------------------------------------------------------
+
 func SplitCuboids(in []Cuboid, splitter Cuboid) []Cuboid {
 	out := make([]Cuboid, 0)
 
@@ -58,26 +436,31 @@ func SplitCuboids(in []Cuboid, splitter Cuboid) []Cuboid {
 	// otherwise keep the entire input cuboid. Leave the splitter untouched.
 
 	for _, inputCuboid := range in {
-		if splitter.Overlaps(&inputCuboid) {
-			fragments := splitter.Split(&inputCuboid)
-			out = append(out, fragments...)
-		} else {
-			out = append(out, inputCuboid)
-		}
+		out = append(out, inputCuboid.Subtract(&splitter)...)
 	}
 
 	return out
 }
 
+// Cuboids may have any number of dimensions up to MaxDims. Unused entries
+// are left as zero so that points and cuboids can be used as map keys.
+const MaxDims = 8
+
+const AxisNames = "xyzwabcd"
+
 type Point struct {
-	v [3]int // x y z
+	v [MaxDims]int
 }
 
-func MakePoint(x, y, z int) Point {
-	return Point{v: [...]int{x, y, z}}
+func MakePoint(v ...int) Point {
+	var p Point
+	copy(p.v[:], v)
+	return p
 }
 
+// A Cuboid covers min.v[i] <= p.v[i] < max.v[i] for each of its dims.
 type Cuboid struct {
+	dims     int
 	min, max Point
 }
 
@@ -97,18 +480,32 @@ func (this Step) GoString() string {
 	return fmt.Sprintf("Turn %s %#v", onOff, this.cuboid)
 }
 
-func MakeCube(size int) Cuboid {
-	min, max := -size, size+1
-	return MakeCuboid(min, max, min, max, min, max)
+func MakeCube(dims, size int) Cuboid {
+	bounds := make([]int, 0, dims*2)
+	for i := 0; i < dims; i++ {
+		bounds = append(bounds, -size, size+1)
+	}
+	return MakeCuboid(bounds...)
 }
 
-func MakeCuboid(xmin, xmax, ymin, ymax, zmin, zmax int) Cuboid {
-	return Cuboid{min: MakePoint(xmin, ymin, zmin),
-		max: MakePoint(xmax, ymax, zmax)}
+// MakeCuboid takes a min and max for each dimension in turn,
+// eg. MakeCuboid(xmin, xmax, ymin, ymax, zmin, zmax).
+func MakeCuboid(bounds ...int) Cuboid {
+	dims := len(bounds) / 2
+	if len(bounds)%2 != 0 || dims < 1 || dims > MaxDims {
+		log.Fatalf("Cuboid needs a min and max for 1 to %d dimensions, got %v", MaxDims, bounds)
+	}
+
+	cuboid := Cuboid{dims: dims}
+	for i := 0; i < dims; i++ {
+		cuboid.min.v[i] = bounds[i*2]
+		cuboid.max.v[i] = bounds[i*2+1]
+	}
+	return cuboid
 }
 
 func (this *Cuboid) IsValid() bool {
-	for i := 0; i < 3; i++ {
+	for i := 0; i < this.dims; i++ {
 		if this.min.v[i] >= this.max.v[i] {
 			return false
 		}
@@ -117,7 +514,7 @@ func (this *Cuboid) IsValid() bool {
 }
 
 func (this *Cuboid) Overlaps(that *Cuboid) bool {
-	for i := 0; i < 3; i++ {
+	for i := 0; i < this.dims; i++ {
 		if this.min.v[i] >= that.max.v[i] {
 			return false
 		}
@@ -129,10 +526,46 @@ func (this *Cuboid) Overlaps(that *Cuboid) bool {
 	return true
 }
 
+func (this *Cuboid) Intersect(that *Cuboid) (Cuboid, bool) {
+	out := Cuboid{dims: this.dims}
+	for i := 0; i < this.dims; i++ {
+		out.min.v[i] = max(this.min.v[i], that.min.v[i])
+		out.max.v[i] = min(this.max.v[i], that.max.v[i])
+	}
+	return out, out.IsValid()
+}
+
+func (this *Cuboid) Contains(p Point) bool {
+	for i := 0; i < this.dims; i++ {
+		if p.v[i] < this.min.v[i] || p.v[i] >= this.max.v[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Corners only makes sense for 3D cuboids.
+func (this *Cuboid) Corners() [8]Point {
+	var corners [8]Point
+	for i := range corners {
+		for axis := 0; axis < 3; axis++ {
+			if i&(1<<axis) == 0 {
+				corners[i].v[axis] = this.min.v[axis]
+			} else {
+				corners[i].v[axis] = this.max.v[axis]
+			}
+		}
+	}
+	return corners
+}
+
+// Split cuts that into up to 3^dims fragments along the faces of this, and
+// returns the fragments which are outside this.
 func (this *Cuboid) Split(that *Cuboid) []Cuboid {
-	var v [3][4]int
+	dims := this.dims
+	v := make([][4]int, dims)
 
-	for i := 0; i < 3; i++ {
+	for i := 0; i < dims; i++ {
 		v[i][0] = this.min.v[i]
 		v[i][1] = this.max.v[i]
 		v[i][2] = that.min.v[i]
@@ -140,92 +573,73 @@ func (this *Cuboid) Split(that *Cuboid) []Cuboid {
 
 		sort.Sort(sort.IntSlice(v[i][0:]))
 	}
-	//fmt.Println(corner)
+
+	lo := make([]int, dims)
+	hi := make([]int, dims)
+	for i := range hi {
+		hi[i] = 3
+	}
 
 	fragments := make([]Cuboid, 0)
-	for x := 0; x < 3; x++ {
-		for y := 0; y < 3; y++ {
-			for z := 0; z < 3; z++ {
-				fragment := MakeCuboid(
-					v[0][x], v[0][x+1],
-					v[1][y], v[1][y+1],
-					v[2][z], v[2][z+1],
-				)
-
-				if !fragment.IsValid() {
-					continue // skip zero-volume fragments
-				}
+	forEachIndex(lo, hi, func(index []int) {
+		fragment := Cuboid{dims: dims}
+		for i, j := range index {
+			fragment.min.v[i] = v[i][j]
+			fragment.max.v[i] = v[i][j+1]
+		}
 
-				// Keep only the fragments that touch that, but not this
-				if !fragment.Overlaps(this) && fragment.Overlaps(that) {
-					fragments = append(fragments, fragment)
-				}
-			}
+		if !fragment.IsValid() {
+			return // skip zero-volume fragments
 		}
-	}
 
-	return fragments
+		// Keep only the fragments that touch that, but not this
+		if !fragment.Overlaps(this) && fragment.Overlaps(that) {
+			fragments = append(fragments, fragment)
+		}
+	})
 
+	return fragments
 }
 
-func (this *Cuboid) Combine(that *Cuboid, doAdd bool) []Cuboid {
-	var corner [3][4]int
-
-	for i := 0; i < 3; i++ {
-		corner[i][0] = this.min.v[i]
-		corner[i][1] = this.max.v[i]
-		corner[i][2] = that.min.v[i]
-		corner[i][3] = that.max.v[i]
-
-		sort.Sort(sort.IntSlice(corner[i][0:]))
-	}
-	//fmt.Println(corner)
-
-	subCuboids := make([]Cuboid, 0)
-	for x := 0; x < 3; x++ {
-		for y := 0; y < 3; y++ {
-			for z := 0; z < 3; z++ {
-				subCuboid := MakeCuboid(
-					corner[0][x], corner[0][x+1],
-					corner[1][y], corner[1][y+1],
-					corner[2][z], corner[2][z+1],
-				)
-
-				if !subCuboid.IsValid() {
-					continue
-				}
-
-				if doAdd {
-					if subCuboid.Overlaps(this) || subCuboid.Overlaps(that) {
-						subCuboids = append(subCuboids, subCuboid)
-					}
-				} else {
-					if subCuboid.Overlaps(this) && !subCuboid.Overlaps(that) {
-						subCuboids = append(subCuboids, subCuboid)
-					}
-				}
-			}
-		}
+// Subtract returns disjoint cuboids covering this but not that.
+func (this *Cuboid) Subtract(that *Cuboid) []Cuboid {
+	if !this.Overlaps(that) {
+		return []Cuboid{*this}
 	}
-
-	return subCuboids
+	return that.Split(this)
 }
 
 func (this *Cuboid) Size() int {
 	size := 1
-	for i := 0; i < 3; i++ {
+	for i := 0; i < this.dims; i++ {
 		size *= this.max.v[i] - this.min.v[i]
 	}
 	return size
 }
 
 func (this Cuboid) GoString() string {
-	return fmt.Sprintf("x=%d..%d,y=%d..%d,z=%d..%d",
-		this.min.v[0], this.max.v[0]-1,
-		this.min.v[1], this.max.v[1]-1,
-		this.min.v[2], this.max.v[2]-1)
+	ranges := make([]string, this.dims)
+	for i := range ranges {
+		ranges[i] = fmt.Sprintf("%c=%d..%d", AxisNames[i], this.min.v[i], this.max.v[i]-1)
+	}
+	return strings.Join(ranges, ",")
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
 
+// All the steps must have the same number of dimensions.
 func ParseInput(filename string) []Step {
 	lines := aoc.GetInputLines(filename)
 
@@ -233,21 +647,62 @@ func ParseInput(filename string) []Step {
 
 	for i, line := range lines {
 		steps[i] = ParseStep(line)
+		if steps[i].cuboid.dims != steps[0].cuboid.dims {
+			log.Fatalf("Step %d has %d dimensions, expected %d",
+				i+1, steps[i].cuboid.dims, steps[0].cuboid.dims)
+		}
 	}
 
 	return steps
 }
 
-var StepRegex = regexp.MustCompile("^(on|off) x=(-?\\d+)\\.\\.(-?\\d+),y=(-?\\d+)\\.\\.(-?\\d+),z=(-?\\d+)\\.\\.(-?\\d+)$")
+func Dims(steps []Step) int {
+	if len(steps) == 0 {
+		return 3
+	}
+	return steps[0].cuboid.dims
+}
+
+var StepRegex = regexp.MustCompile("^(on|off) (.*)$")
+var RangeRegex = regexp.MustCompile("^([a-z])=(-?\\d+)\\.\\.(-?\\d+)$")
 
+// ParseStep reads one range per dimension, named in the order of AxisNames,
+// eg. "on x=1..2,y=3..4" is a 2D step.
 func ParseStep(line string) Step {
 	matches := StepRegex.FindStringSubmatch(line)
+	if len(matches) != 3 {
+		log.Fatalf("Bad step %q", line)
+	}
 	isOn := (matches[1] == "on")
 
-	v := make([]int, 6)
-	for i := 0; i < 6; i++ {
-		v[i] = aoc.ParseInt(matches[i+2])
+	return Step{cuboid: ParseCuboid(matches[2]), isOn: isOn}
+}
+
+// ParseCuboid reads inclusive ranges such as "x=1..2,y=3..4".
+func ParseCuboid(text string) Cuboid {
+	ranges := strings.Split(text, ",")
+	if len(ranges) > MaxDims {
+		log.Fatalf("Too many dimensions in %q", text)
 	}
 
-	return Step{cuboid: MakeCuboid(v[0], v[1]+1, v[2], v[3]+1, v[4], v[5]+1), isOn: isOn}
+	v := make([]int, 0, len(ranges)*2)
+	for i, r := range ranges {
+		m := RangeRegex.FindStringSubmatch(r)
+		if len(m) != 4 || m[1][0] != AxisNames[i] {
+			log.Fatalf("Expected %c=min..max, got %q", AxisNames[i], r)
+		}
+		v = append(v, aoc.ParseInt(m[2]), aoc.ParseInt(m[3])+1)
+	}
+
+	return MakeCuboid(v...)
+}
+
+// ParsePoint reads comma-separated coordinates, and also returns how many
+// there were.
+func ParsePoint(text string) (Point, int) {
+	v := aoc.ParseInts(strings.Split(text, ","))
+	if len(v) > MaxDims {
+		log.Fatalf("Too many dimensions in %q", text)
+	}
+	return MakePoint(v...), len(v)
 }
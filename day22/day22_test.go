@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+)
+
+// cube makes a cuboid covering lo <= v < hi on every axis.
+func cube(dims, lo, hi int) Cuboid {
+	bounds := make([]int, 0, dims*2)
+	for i := 0; i < dims; i++ {
+		bounds = append(bounds, lo, hi)
+	}
+	return MakeCuboid(bounds...)
+}
+
+func pow(base, exp int) int {
+	result := 1
+	for i := 0; i < exp; i++ {
+		result *= base
+	}
+	return result
+}
+
+func TestCuboidAlgebra(t *testing.T) {
+	for dims := 1; dims <= 4; dims++ {
+		a := cube(dims, 0, 4)
+		b := cube(dims, 2, 6)
+		apart := cube(dims, 10, 12)
+
+		if got, want := a.Size(), pow(4, dims); got != want {
+			t.Errorf("%dD: size of %#v is %d, want %d", dims, a, got, want)
+		}
+
+		overlap, found := a.Intersect(&b)
+		if !found || overlap != cube(dims, 2, 4) {
+			t.Errorf("%dD: %#v intersect %#v gave %#v, %v", dims, a, b, overlap, found)
+		}
+		if _, found := a.Intersect(&apart); found {
+			t.Errorf("%dD: %#v and %#v should not intersect", dims, a, apart)
+		}
+
+		fragments := a.Subtract(&b)
+		total := 0
+		for i, fragment := range fragments {
+			total += fragment.Size()
+			if fragment.Overlaps(&b) {
+				t.Errorf("%dD: fragment %#v overlaps %#v", dims, fragment, b)
+			}
+			if inside, _ := fragment.Intersect(&a); inside != fragment {
+				t.Errorf("%dD: fragment %#v is not inside %#v", dims, fragment, a)
+			}
+			for _, other := range fragments[i+1:] {
+				if fragment.Overlaps(&other) {
+					t.Errorf("%dD: fragments %#v and %#v overlap", dims, fragment, other)
+				}
+			}
+		}
+		if want := pow(4, dims) - pow(2, dims); total != want {
+			t.Errorf("%dD: %#v minus %#v has size %d, want %d", dims, a, b, total, want)
+		}
+
+		if fragments := a.Subtract(&apart); len(fragments) != 1 || fragments[0] != a {
+			t.Errorf("%dD: %#v minus %#v gave %v", dims, a, apart, fragments)
+		}
+	}
+}
+
+func TestBackends(t *testing.T) {
+	for _, test := range []struct {
+		filename     string
+		part1, part2 int
+	}{
+		{"example01.txt", 39, 39},
+		{"example02.txt", 590784, 39769202357779},
+		{"example03.txt", 474140, 2758514936282235},
+		{"example04.txt", 79, 79},
+		{"example05.txt", 23, 25},
+	} {
+		steps := ParseInput(test.filename)
+		bounds := MakeCube(Dims(steps), 50)
+		for _, name := range BackendNames {
+			backend := Backends[name]
+			if got := part1(bounds, steps, backend); got != test.part1 {
+				t.Errorf("%s part 1 with %s: got %d, want %d", test.filename, name, got, test.part1)
+			}
+			if got := part2(steps, backend); got != test.part2 {
+				t.Errorf("%s part 2 with %s: got %d, want %d", test.filename, name, got, test.part2)
+			}
+		}
+	}
+}
+
+func TestReactorQueries(t *testing.T) {
+	reactor := MakeReactor()
+	reactor.ApplyAll(ParseInput("example04.txt"))
+
+	if got := reactor.LitVolumeIn(MakeCuboid(0, 5, 0, 5)); got != 20 {
+		t.Errorf("lit volume in x=0..4,y=0..4: got %d, want 20", got)
+	}
+	for _, test := range []struct {
+		p    Point
+		isOn bool
+	}{
+		{MakePoint(0, 0), true},
+		{MakePoint(1, 1), false},
+		{MakePoint(7, 7), false},
+		{MakePoint(-2, -2), true},
+	} {
+		if got := reactor.IsOn(test.p); got != test.isOn {
+			t.Errorf("IsOn(%v): got %v, want %v", test.p.v[:2], got, test.isOn)
+		}
+	}
+
+	total := 0
+	for _, cuboid := range reactor.Cuboids() {
+		total += cuboid.Size()
+	}
+	if total != reactor.LitVolume() {
+		t.Errorf("cuboids cover %d, but lit volume is %d", total, reactor.LitVolume())
+	}
+}
@@ -2,18 +2,25 @@ package main
 
 import (
 	"advent-of-code/aoc"
+	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"math/bits"
+	"math/rand"
 	"strings"
 )
 
 type Item uint8
 
 const (
-	Empty    Item = '.'
-	East          = '>'
-	South         = 'v'
-	Leaving       = 'o'
-	Entering      = '*'
+	Empty Item = '.'
+	East       = '>'
+	South      = 'v'
 )
 
 type Seafloor struct {
@@ -53,76 +60,447 @@ func (this Seafloor) String() string {
 	return b.String()
 }
 
-func (this *Seafloor) Step() int {
+// RandomSeafloor fills each position with a cucumber with the given
+// probability, split evenly between the two herds.
+func RandomSeafloor(w, h int, density float64, rng *rand.Rand) Seafloor {
+	pos := make([]Item, w*h)
+	for i := range pos {
+		switch r := rng.Float64(); {
+		case r < density/2:
+			pos[i] = East
+		case r < density:
+			pos[i] = South
+		default:
+			pos[i] = Empty
+		}
+	}
+	return Seafloor{w, h, pos}
+}
+
+func (this Seafloor) Clone() Seafloor {
+	return Seafloor{this.w, this.h, append([]Item(nil), this.pos...)}
+}
+
+// Moves counts how many of each herd moved during a step.
+type Moves struct {
+	east, south int
+}
 
+func (this Moves) Total() int {
+	return this.east + this.south
+}
+
+// Step moves the east herd and then the south herd. Each herd decides where
+// to move based on the seafloor before any of that herd has moved.
+func (this *Seafloor) Step() Moves {
+	var moves Moves
+	moves.east = this.moveHerd(East, 1, 0)
+	moves.south = this.moveHerd(South, 0, 1)
+	return moves
+}
+
+func (this *Seafloor) moveHerd(herd Item, dx, dy int) int {
+	next := append([]Item(nil), this.pos...)
 	moves := 0
 
-	// Eastbound
 	for y := 0; y < this.h; y++ {
 		for x := 0; x < this.w; x++ {
-			p := &this.pos[this.index(x, y)]
-			q := &this.pos[this.index(x+1, y)]
+			p := this.index(x, y)
+			q := this.index(x+dx, y+dy)
 
-			if *p == East && *q == Empty {
-				*p, *q = Leaving, Entering
+			if this.pos[p] == herd && this.pos[q] == Empty {
+				next[p], next[q] = Empty, herd
 				moves++
 			}
 		}
+	}
+
+	this.pos = next
+	return moves
+}
+
+func (this *Seafloor) index(x, y int) int {
+	x %= this.w
+	y %= this.h
+	return y*this.w + x
+}
+
+//------------------------------------------------------------------------------
+
+// An Outcome describes how a simulation ended. The seafloor after step
+// cycleStart is the same as after step cycleStart+period, so a period of one
+// means the cucumbers have stopped moving.
+type Outcome struct {
+	steps      int
+	cycleStart int
+	period     int
+	moves      []Moves    // per step, starting with step 1
+	frames     []Seafloor // the initial seafloor and then one per step
+}
+
+// Simulate runs until the seafloor repeats a previous state, or until
+// maxSteps have been taken if maxSteps is positive. Frames are only kept
+// if record is set.
+//
+// Only a hash of each state is remembered. When a hash turns up again, the
+// earlier state is recreated by replaying the steps to it, and compared.
+func Simulate(seafloor Seafloor, record bool, maxSteps int) Outcome {
+	board := MakeBitSeafloor(seafloor)
+
+	outcome := Outcome{moves: make([]Moves, 0)}
+	seen := map[uint64][]int{board.Hash(): {0}}
+	if record {
+		outcome.frames = []Seafloor{board.Seafloor()}
+	}
+
+	for maxSteps <= 0 || outcome.steps < maxSteps {
+		moves := board.Step()
+		outcome.steps++
+		outcome.moves = append(outcome.moves, moves)
+		if record {
+			outcome.frames = append(outcome.frames, board.Seafloor())
+		}
+
+		hash := board.Hash()
+		for _, step := range seen[hash] {
+			if earlier := replay(seafloor, step); earlier.Equal(&board) {
+				outcome.cycleStart = step
+				outcome.period = outcome.steps - step
+				return outcome
+			}
+		}
+		seen[hash] = append(seen[hash], outcome.steps)
+	}
+
+	return outcome
+}
+
+func replay(seafloor Seafloor, steps int) BitSeafloor {
+	board := MakeBitSeafloor(seafloor)
+	for i := 0; i < steps; i++ {
+		board.Step()
+	}
+	return board
+}
+
+func (this Outcome) WriteText(w io.Writer) error {
+	for i, frame := range this.frames {
+		if _, err := fmt.Fprintf(w, "After %d steps:\n%s\n", i, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var Palette = color.Palette{
+	color.RGBA{0x00, 0x1f, 0x3f, 0xff}, // Empty
+	color.RGBA{0xff, 0x85, 0x1b, 0xff}, // East
+	color.RGBA{0x2e, 0xcc, 0x40, 0xff}, // South
+}
+
+// Scale is the size in pixels of each seafloor position in an image.
+const Scale = 4
+
+func (this Seafloor) Image() *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, this.w*Scale, this.h*Scale), Palette)
+
+	for y := 0; y < this.h; y++ {
 		for x := 0; x < this.w; x++ {
-			p := &this.pos[this.index(x, y)]
-			if *p == Leaving {
-				*p = Empty
-			} else if *p == Entering {
-				*p = East
+			var index uint8
+			switch this.pos[this.index(x, y)] {
+			case East:
+				index = 1
+			case South:
+				index = 2
+			}
+			for py := 0; py < Scale; py++ {
+				for px := 0; px < Scale; px++ {
+					img.SetColorIndex(x*Scale+px, y*Scale+py, index)
+				}
 			}
 		}
 	}
+	return img
+}
 
-	//fmt.Println(this)
+func (this Outcome) WriteGIF(w io.Writer) error {
+	anim := gif.GIF{}
+	for _, frame := range this.frames {
+		anim.Image = append(anim.Image, frame.Image())
+		anim.Delay = append(anim.Delay, 10)
+	}
+	return gif.EncodeAll(w, &anim)
+}
 
-	// Southbound
-	for x := 0; x < this.w; x++ {
-		for y := 0; y < this.h; y++ {
-			p := &this.pos[this.index(x, y)]
-			q := &this.pos[this.index(x, y+1)]
+// WritePNGs writes one file per frame, named prefix0000.png and so on.
+func (this Outcome) WritePNGs(prefix string) error {
+	for i, frame := range this.frames {
+		err := aoc.WriteFile(fmt.Sprintf("%s%04d.png", prefix, i), func(w io.Writer) error {
+			return png.Encode(w, frame.Image())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			if *p == South && *q == Empty {
-				*p, *q = Leaving, Entering
-				moves++
+//------------------------------------------------------------------------------
+
+// A BitSeafloor keeps one bitset per herd. Each row takes stride words, with
+// position x in bit x%64 of word x/64. Bits past the end of a row are zero.
+type BitSeafloor struct {
+	w, h        int
+	stride      int
+	east, south []uint64
+	lastMask    uint64 // the valid bits of the last word in each row
+	scratch     []uint64
+}
+
+func MakeBitSeafloor(seafloor Seafloor) BitSeafloor {
+	stride := (seafloor.w + 63) / 64
+	this := BitSeafloor{
+		w:        seafloor.w,
+		h:        seafloor.h,
+		stride:   stride,
+		east:     make([]uint64, stride*seafloor.h),
+		south:    make([]uint64, stride*seafloor.h),
+		lastMask: ^uint64(0) >> (stride*64 - seafloor.w),
+		scratch:  make([]uint64, 2*stride),
+	}
+
+	for y := 0; y < this.h; y++ {
+		for x := 0; x < this.w; x++ {
+			bit := uint64(1) << (x & 63)
+			switch seafloor.pos[seafloor.index(x, y)] {
+			case East:
+				this.east[y*stride+x>>6] |= bit
+			case South:
+				this.south[y*stride+x>>6] |= bit
+			}
+		}
+	}
+	return this
+}
+
+func (this *BitSeafloor) Seafloor() Seafloor {
+	pos := make([]Item, this.w*this.h)
+	for y := 0; y < this.h; y++ {
+		for x := 0; x < this.w; x++ {
+			word, bit := y*this.stride+x>>6, uint64(1)<<(x&63)
+			switch {
+			case this.east[word]&bit != 0:
+				pos[y*this.w+x] = East
+			case this.south[word]&bit != 0:
+				pos[y*this.w+x] = South
+			default:
+				pos[y*this.w+x] = Empty
 			}
 		}
-		for y := 0; y < this.h; y++ {
-			p := &this.pos[this.index(x, y)]
-			if *p == Leaving {
-				*p = Empty
-			} else if *p == Entering {
-				*p = South
+	}
+	return Seafloor{this.w, this.h, pos}
+}
+
+// Hash mixes the state of the seafloor into 64 bits. Different states may
+// share a hash, so matches need checking with Equal.
+func (this *BitSeafloor) Hash() uint64 {
+	hash := uint64(14695981039346656037)
+	for _, words := range [][]uint64{this.east, this.south} {
+		for _, word := range words {
+			hash = (hash ^ word) * 1099511628211
+			hash ^= hash >> 29
+		}
+	}
+	return hash
+}
+
+func (this *BitSeafloor) Equal(that *BitSeafloor) bool {
+	if this.w != that.w || this.h != that.h {
+		return false
+	}
+	for i := range this.east {
+		if this.east[i] != that.east[i] || this.south[i] != that.south[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rotateRight sets dst[x] = src[x+1], wrapping around at the end of the row.
+func (this *BitSeafloor) rotateRight(dst, src []uint64) {
+	last := this.stride - 1
+	for i := 0; i < last; i++ {
+		dst[i] = src[i]>>1 | src[i+1]<<63
+	}
+	dst[last] = src[last]>>1 | (src[0]&1)<<((this.w-1)&63)
+}
+
+// rotateLeft sets dst[x] = src[x-1], wrapping around at the start of the row.
+func (this *BitSeafloor) rotateLeft(dst, src []uint64) {
+	last := this.stride - 1
+	top := (src[last] >> ((this.w - 1) & 63)) & 1
+	for i := last; i > 0; i-- {
+		dst[i] = src[i]<<1 | src[i-1]>>63
+	}
+	dst[0] = src[0]<<1 | top
+	dst[last] &= this.lastMask
+}
+
+func (this *BitSeafloor) Step() Moves {
+	var moves Moves
+	stride := this.stride
+	blocked := this.scratch[:stride]
+	movers := this.scratch[stride:]
+
+	// Eastbound: a cucumber moves if the position to its right is empty.
+	for y := 0; y < this.h; y++ {
+		east := this.east[y*stride : (y+1)*stride]
+		south := this.south[y*stride : (y+1)*stride]
+
+		for i := range blocked {
+			movers[i] = east[i] | south[i]
+		}
+		this.rotateRight(blocked, movers)
+		for i := range movers {
+			movers[i] = east[i] &^ blocked[i]
+			moves.east += bits.OnesCount64(movers[i])
+			east[i] &^= movers[i]
+		}
+		this.rotateLeft(blocked, movers)
+		for i := range east {
+			east[i] |= blocked[i]
+		}
+	}
+
+	// Southbound: a cucumber moves if the position below it is empty. Row 0
+	// is saved first, as it may fill up before the last row looks at it.
+	firstRow := make([]uint64, stride)
+	for i := range firstRow {
+		firstRow[i] = this.east[i] | this.south[i]
+	}
+	carry := make([]uint64, stride)
+	for y := 0; y < this.h; y++ {
+		south := this.south[y*stride : (y+1)*stride]
+		var below []uint64
+		if y == this.h-1 {
+			below = firstRow
+		} else {
+			below = movers
+			nextEast := this.east[(y+1)*stride : (y+2)*stride]
+			nextSouth := this.south[(y+1)*stride : (y+2)*stride]
+			for i := range below {
+				below[i] = nextEast[i] | nextSouth[i]
 			}
 		}
+
+		for i := range south {
+			moved := south[i] &^ below[i]
+			moves.south += bits.OnesCount64(moved)
+			south[i] = south[i]&^moved | carry[i]
+			carry[i] = moved
+		}
+	}
+	for i, moved := range carry {
+		this.south[i] |= moved
 	}
 
 	return moves
 }
 
-func (this *Seafloor) index(x, y int) int {
-	x %= this.w
-	y %= this.h
-	return y*this.w + x
-}
+//------------------------------------------------------------------------------
+
+var textFile = flag.String("text", "", "write every frame as text to this file")
+var gifFile = flag.String("gif", "", "write an animated GIF to this file")
+var pngPrefix = flag.String("png", "", "write one PNG per frame with this filename prefix")
+var showMoves = flag.Bool("moves", false, "print how many of each herd moved on every step")
+var maxSteps = flag.Int("max", 0, "stop after this many steps, if positive")
+var bench = flag.Bool("bench", false, "benchmark byte and bitset seafloors")
+var generate = flag.String("generate", "", "write a random WxH seafloor to the input file")
+var density = flag.Float64("density", 0.5, "fraction of generated positions with a cucumber")
+var seed = flag.Int64("seed", 1, "random seed for -generate")
+
+// BenchSteps is how many steps each benchmark iteration runs.
+const BenchSteps = 100
 
 func main() {
-	fmt.Println(part1(aoc.GetFilename()))
-}
+	filename := aoc.GetFilename()
+
+	if *generate != "" {
+		var w, h int
+		if _, err := fmt.Sscanf(*generate, "%dx%d", &w, &h); err != nil || w < 1 || h < 1 {
+			log.Fatalf("Bad size %q, expected WxH", *generate)
+		}
+		seafloor := RandomSeafloor(w, h, *density, rand.New(rand.NewSource(*seed)))
+		aoc.CheckErr(aoc.WriteFile(filename, func(w io.Writer) error {
+			_, err := io.WriteString(w, seafloor.String())
+			return err
+		}))
+		return
+	}
 
-func part1(filename string) int {
 	seafloor := NewSeafloor(filename)
 
-	steps := 0
-	for {
-		moves := seafloor.Step()
-		steps++
-		if moves == 0 {
-			return steps
+	if *bench {
+		benchmark(seafloor)
+		return
+	}
+	record := *textFile != "" || *gifFile != "" || *pngPrefix != ""
+
+	outcome := Simulate(seafloor, record, *maxSteps)
+
+	if *showMoves {
+		for i, moves := range outcome.moves {
+			fmt.Printf("step %d: east=%d south=%d\n", i+1, moves.east, moves.south)
 		}
 	}
+
+	if steps, found := part1(outcome); found {
+		fmt.Println(steps)
+	} else if outcome.period > 1 {
+		fmt.Printf("cycle of period %d from step %d\n", outcome.period, outcome.cycleStart)
+	} else {
+		fmt.Printf("no repeat within %d steps\n", outcome.steps)
+	}
+
+	if *textFile != "" {
+		aoc.CheckErr(aoc.WriteFile(*textFile, outcome.WriteText))
+	}
+	if *gifFile != "" {
+		aoc.CheckErr(aoc.WriteFile(*gifFile, outcome.WriteGIF))
+	}
+	if *pngPrefix != "" {
+		aoc.CheckErr(outcome.WritePNGs(*pngPrefix))
+	}
+}
+
+func benchmark(seafloor Seafloor) {
+	bytes := seafloor.Clone()
+	board := MakeBitSeafloor(seafloor)
+	for i := 0; i < BenchSteps; i++ {
+		if bytes.Step() != board.Step() {
+			log.Fatalf("Byte and bitset seafloors disagree on step %d", i+1)
+		}
+	}
+	if bytes.String() != board.Seafloor().String() {
+		log.Fatalf("Byte and bitset seafloors disagree after %d steps", BenchSteps)
+	}
+
+	aoc.Benchmark("bytes", func() {
+		bytes := seafloor.Clone()
+		for i := 0; i < BenchSteps; i++ {
+			bytes.Step()
+		}
+	})
+	aoc.Benchmark("bitset", func() {
+		board := MakeBitSeafloor(seafloor)
+		for i := 0; i < BenchSteps; i++ {
+			board.Step()
+		}
+	})
+}
+
+// The first step on which nothing moves is the one after the seafloor
+// reaches its fixpoint.
+func part1(outcome Outcome) (int, bool) {
+	return outcome.steps, outcome.period == 1
 }